@@ -0,0 +1,77 @@
+package sio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// udev keeps persistent names for serial ports as symlinks here
+const DevSerialRoot = DevRoot + "/serial"
+const ById = "by-id"
+const ByPath = "by-path"
+
+// StableNames returns the /dev/serial/by-id and by-path links that point
+// to device, the ones udev made, not the kernel; none if udev is not there
+func StableNames(device string) (by_id, by_path []string) {
+	return stableNames(DevSerialRoot, device)
+}
+
+func stableNames(root, device string) (by_id, by_path []string) {
+	target, e := filepath.EvalSymlinks(device)
+	if e != nil {
+		return nil, nil
+	}
+	var links = func(dir string) (found []string) {
+		entries, e := ioutil.ReadDir(filepath.Join(root, dir))
+		if e != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			link := filepath.Join(root, dir, entry.Name())
+			if path, e := filepath.EvalSymlinks(link); e == nil && path == target {
+				found = append(found, link)
+			}
+		}
+		return found
+	}
+	return links(ById), links(ByPath)
+}
+
+// ResolvePath is the other way round: it follows symlinks down to the
+// kernel's device. A bare name is looked up in by-id and by-path, so
+// "usb-FTDI_FT232R_USB_UART_A10K3X-if00-port0" is fine.
+func ResolvePath(path string) (string, error) {
+	return resolvePath(DevSerialRoot, path)
+}
+
+func resolvePath(root, path string) (string, error) {
+	if !strings.Contains(path, "/") && !exists(path) {
+		for _, dir := range []string{ById, ByPath} {
+			if link := filepath.Join(root, dir, path); exists(link) {
+				path = link
+				break
+			}
+		}
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// StableNames are the port's by-id and by-path names as of Open()
+func (self *Port) StableNames() (by_id, by_path []string) {
+	return self.by_id, self.by_path
+}
+
+// stableName is the one to put in logs: by-id says what the adapter is,
+// by-path only where it is plugged in
+func stableName(by_id, by_path []string) string {
+	if len(by_id) > 0 {
+		return by_id[0]
+	}
+	if len(by_path) > 0 {
+		return by_path[0]
+	}
+	return ""
+}
+
+/* EOF */
@@ -4,6 +4,21 @@ package sio
 /*
 #include <termios.h>
 #include <unistd.h>
+#include <signal.h>
+#include <string.h>
+
+static void sio_wakeup(int sig) {
+}
+
+// no SA_RESTART: the syscall the thread is blocked in returns EINTR
+static int sio_install_wakeup(int sig) {
+	struct sigaction sa;
+	memset(&sa, 0, sizeof(sa));
+	sa.sa_handler = sio_wakeup;
+	sa.sa_flags = SA_ONSTACK;
+	sigemptyset(&sa.sa_mask);
+	return sigaction(sig, &sa, NULL);
+}
 */
 import "C"
 import "syscall"
@@ -76,4 +91,13 @@ func tcFlush(fd Ioctl, qsel int) error {
 	}
 }
 
+// installWakeup gives sig a handler that does nothing, see wakeable
+func installWakeup(sig syscall.Signal) error {
+	r, err := C.sio_install_wakeup(C.int(sig))
+	if r < 0 {
+		return err
+	}
+	return nil
+}
+
 /* EOF */
@@ -0,0 +1,289 @@
+package sio
+
+import "time"
+import "syscall"
+
+// PortConfig describes how a Port is to be configured on open.
+// Start from DefaultPortConfig() and change what you need.
+type PortConfig struct {
+	Exclusive bool
+	Speed BitRate
+	InputSpeed BitRate // zero means "same as Speed"
+	CharSize CharSize
+	Parity Parity
+	StopBits StopBits
+	XonXoff, RtsCts, DsrDtr bool
+	DsrDtrOptions DsrDtrOptions // see DsrDtr
+	DTR, RTS LineLevel // on open; LINE_KEEP does not touch them
+	// HUPCL: drop DTR and RTS on last close. Close() puts the original
+	// termios back first, so true leaves it to what the device had unless
+	// KeepSettings; false clears HUPCL either way and it stays cleared.
+	HangupOnClose bool
+	MarkErrors bool // report parity/framing errors and BREAKs, see LineErrors
+	Rs485 Rs485
+	ReadTimeout, WriteTimeout time.Duration // see InfiniteTimeout
+	InterByteTimeout time.Duration // see ReadFrame
+	KeepSettings bool // do not restore the original settings on Close
+}
+
+// DefaultPortConfig returns what Port.Open() uses: 9600 8N1, exclusive,
+// no flow control, no RS485, DTR and RTS left alone.
+func DefaultPortConfig() PortConfig {
+	return PortConfig{
+		Exclusive: true,
+		Speed: BIT_RATE_B9600,
+		CharSize: CHAR_SIZE_8,
+		Parity: PARITY_NONE,
+		StopBits: STOP_BITS_1,
+		HangupOnClose: true,
+		DsrDtrOptions: DefaultDsrDtrOptions,
+		ReadTimeout: DefaultTimeout,
+		WriteTimeout: DefaultTimeout,
+	}
+}
+
+func (self *PortConfig) Validate() error {
+	// non-standard speeds are fine, they go via BOTHER; B0 drops the line
+	if self.Speed == BIT_RATE_B0 {
+		return NewPortError("PortConfig: invalid speed %v", self.Speed)
+	}
+	if !IsValidCharSize(int(self.CharSize)) {
+		return NewPortError("PortConfig: invalid char size %v", self.CharSize)
+	}
+	if !IsValidParity(int(self.Parity)) {
+		return NewPortError("PortConfig: invalid parity %v", self.Parity)
+	}
+	if !IsValidStopBits(int(self.StopBits)) {
+		return NewPortError("PortConfig: invalid stop bits %v", self.StopBits)
+	}
+	if self.DTR > LINE_HIGH || self.RTS > LINE_HIGH {
+		return NewPortError("PortConfig: invalid DTR/RTS level %v/%v", self.DTR, self.RTS)
+	}
+	if self.Rs485.Enabled && self.Rs485.Software > RS485_SOFT_ALWAYS {
+		return NewPortError("PortConfig: invalid RS485 software mode %v", self.Rs485.Software)
+	}
+	if self.Rs485.Software != RS485_SOFT_NEVER && self.Rs485.Use_dtr && self.DsrDtr {
+		return NewPortError("PortConfig: DTR can't do both RS485 and DSR/DTR")
+	}
+	if self.DsrDtr {
+		if e := self.DsrDtrOptions.Validate(); e != nil {
+			return e
+		}
+	}
+	if self.InterByteTimeout < 0 {
+		return NewPortError("PortConfig: invalid inter-byte timeout %v", self.InterByteTimeout)
+	}
+	return nil
+}
+
+// Config returns the configuration the port was opened with.
+func (self *Port) Config() PortConfig {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	return self.config()
+}
+
+func (self *Port) config() PortConfig {
+	return PortConfig{
+		Exclusive: self.exclusive,
+		Speed: self.speed,
+		InputSpeed: self.ispeed,
+		CharSize: self.char_size,
+		Parity: self.parity,
+		StopBits: self.stop_bits,
+		XonXoff: self.xonxoff,
+		RtsCts: self.rtscts,
+		DsrDtr: self.dsrdtr,
+		DsrDtrOptions: self.dsrdtr_options,
+		DTR: self.dtr,
+		RTS: self.rts,
+		HangupOnClose: self.hupcl,
+		MarkErrors: self.mark_errors,
+		Rs485: self.rs485,
+		ReadTimeout: self.read_timeout,
+		WriteTimeout: self.write_timeout,
+		InterByteTimeout: self.inter_byte_timeout,
+		KeepSettings: self.keep_settings,
+	}
+}
+
+func (self *Port) useConfig(cfg *PortConfig) {
+	self.exclusive = cfg.Exclusive
+	self.speed = cfg.Speed
+	self.ispeed = cfg.InputSpeed
+	self.char_size = cfg.CharSize
+	self.parity = cfg.Parity
+	self.stop_bits = cfg.StopBits
+	self.xonxoff = cfg.XonXoff
+	self.rtscts = cfg.RtsCts
+	self.dsrdtr = cfg.DsrDtr
+	self.dsrdtr_options = cfg.DsrDtrOptions
+	self.dtr, self.rts = cfg.DTR, cfg.RTS
+	self.hupcl = cfg.HangupOnClose
+	self.mark_errors = cfg.MarkErrors
+	self.rs485 = cfg.Rs485
+	self.read_timeout = cfg.ReadTimeout
+	self.write_timeout = cfg.WriteTimeout
+	self.inter_byte_timeout = cfg.InterByteTimeout
+	self.keep_settings = cfg.KeepSettings
+}
+
+// ApplyConfig reconfigures an open port. It waits for the read and the
+// write in progress, if any: they do not see the settings change under
+// them. If anything fails the previous settings are put back.
+func (self *Port) ApplyConfig(cfg PortConfig) (e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	self.rlock.Lock(); defer self.rlock.Unlock()
+	self.wlock.Lock(); defer self.wlock.Unlock()
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return PortNotOpenError; }
+
+	e = cfg.Validate()
+	assert(e, "ApplyConfig: %w", e)
+
+	var old PortConfig = self.config()
+	var termios syscall.Termios
+	termios, e = self.fd.TcGetAttr()
+	assert(e, "ApplyConfig: %w", e)
+	var ispeed, ospeed BitRate // TCSETS does not put BOTHER speeds back
+	ispeed, ospeed, e = self.fd.TcGetSpeed()
+	assert(e, "ApplyConfig: %w", e)
+
+	self.useConfig(&cfg)
+	e = self.reconfigure(termios, &old)
+	if e != nil {
+		self.useConfig(&old)
+		self.rollback(termios, ispeed, ospeed, &cfg)
+		assert(e, "ApplyConfig")
+	}
+	return nil
+}
+
+func (self *Port) reconfigure(termios syscall.Termios, old *PortConfig) (e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	if self.exclusive != old.Exclusive {
+		e = self.fd.SetExclusive(self.exclusive)
+		assert(e, "SetExclusive(%v): %w", self.exclusive, e)
+	}
+	soft := self.rs485_soft // the driver has nothing to switch off then
+	e = self.fd.Apply(termios, self)
+	assert(e, "Apply: %w", e)
+	if old.Rs485.Enabled && !self.rs485.Enabled && !soft {
+		e = self.fd.SetRs485(self.rs485)
+		assert(e, "SetRs485")
+	}
+	self.stop_dsrdtr() // the options may have changed
+	if self.dsrdtr {
+		e = self.start_dsrdtr()
+		assert(e, "DSR/DTR handshake")
+	}
+	return nil
+}
+
+// rollback is best effort: the port is already in trouble
+func (self *Port) rollback(termios syscall.Termios, ispeed, ospeed BitRate, failed *PortConfig) {
+	self.fd.TcSetAttr(termios)
+	if termios.Cflag & CBAUD == BOTHER {
+		self.fd.TcSetSpeed(ispeed, ospeed)
+	}
+	if failed.Exclusive != self.exclusive {
+		self.fd.SetExclusive(self.exclusive)
+	}
+	if failed.Rs485 != self.rs485 {
+		if self.rs485.Enabled {
+			self.rs485_soft, _ = self.fd.useRs485(self.rs485)
+		} else {
+			self.fd.SetRs485(self.rs485)
+			self.rs485_soft = false
+		}
+	}
+	self.stop_dsrdtr()
+	if self.dsrdtr {
+		self.start_dsrdtr()
+	}
+}
+
+func (self *Port) SetSpeed(speed BitRate) error {
+	cfg := self.Config()
+	cfg.Speed = speed
+	return self.ApplyConfig(cfg)
+}
+func (self *Port) SetInputSpeed(speed BitRate) error {
+	cfg := self.Config()
+	cfg.InputSpeed = speed
+	return self.ApplyConfig(cfg)
+}
+
+// ActualSpeed returns the input and output speeds the driver has applied
+func (self *Port) ActualSpeed() (ispeed, ospeed BitRate, e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return 0, 0, PortNotOpenError; }
+	return self.fd.TcGetSpeed()
+}
+
+func (self *Port) SetCharSize(size CharSize) error {
+	cfg := self.Config()
+	cfg.CharSize = size
+	return self.ApplyConfig(cfg)
+}
+func (self *Port) SetParity(parity Parity) error {
+	cfg := self.Config()
+	cfg.Parity = parity
+	return self.ApplyConfig(cfg)
+}
+func (self *Port) SetStopBits(stop StopBits) error {
+	cfg := self.Config()
+	cfg.StopBits = stop
+	return self.ApplyConfig(cfg)
+}
+func (self *Port) SetInterByteTimeout(d time.Duration) error {
+	cfg := self.Config()
+	cfg.InterByteTimeout = d
+	return self.ApplyConfig(cfg)
+}
+func (self *Port) SetXonXoff(set bool) error {
+	cfg := self.Config()
+	cfg.XonXoff = set
+	return self.ApplyConfig(cfg)
+}
+func (self *Port) SetRtsCts(set bool) error {
+	cfg := self.Config()
+	cfg.RtsCts = set
+	return self.ApplyConfig(cfg)
+}
+func (self *Port) SetDsrDtr(set bool) error {
+	cfg := self.Config()
+	cfg.DsrDtr = set
+	return self.ApplyConfig(cfg)
+}
+
+// OpenWithConfig("/dev/ttyUSB0", cfg) returns an open Port or an error
+func OpenWithConfig(path string, cfg PortConfig) (*Port, error) {
+	var p *Port = &Port{}
+	e := p.OpenWithConfig(path, cfg)
+	if e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+// NewSerialPortWithConfig is NewSerialPort() with explicit configuration
+func NewSerialPortWithConfig(dev string, cfg PortConfig) *Port {
+	p, e := OpenWithConfig(dev, cfg)
+	assert(e, "NewSerialPortWithConfig(%+q): %w", dev, e)
+	return p
+}
+
+/* EOF */
@@ -0,0 +1,97 @@
+package sio
+
+import "context"
+
+// watch wakes up the I/O blocked on the pipe once ctx is done.
+// Call stop() after the I/O: it tells if ctx has fired and eats the
+// notification if the I/O did not, and only that one: a CancelRead()
+// that came meanwhile is still there for the next I/O.
+func watch(ctx context.Context, pipe *servicePipe) (stop func() bool) {
+	done := make(chan bool)
+	fired := make(chan bool, 1)
+	go func() {
+		select {
+		case <- ctx.Done():
+			pipe.Notify()
+			fired <- true
+		case <- done:
+			fired <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		if <- fired {
+			pipe.FetchOne() // EAGAIN if the I/O got it already
+			return true
+		}
+		return false
+	}
+}
+
+// ReadContext is Read() that returns ctx.Err() once ctx is done.
+// ReadTimeout() still applies, use InfiniteTimeout to rely on ctx only.
+func (self *Port) ReadContext(ctx context.Context, data []byte) (n int, e error) {
+	self.rlock.Lock(); defer self.rlock.Unlock()
+
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+	if e = ctx.Err(); e != nil {
+		return 0, e
+	}
+	stop := watch(ctx, &self.pipe.abort_read)
+	defer func() {
+		if stop() {
+			e = ctx.Err()
+		}
+	}()
+	return self.read_some(data, newTimeout(self.ReadTimeout()))
+}
+
+// WriteContext is Write() that returns ctx.Err() and the number of bytes
+// sent so far once ctx is done.
+func (self *Port) WriteContext(ctx context.Context, data []byte) (n int, e error) {
+	self.wlock.Lock(); defer self.wlock.Unlock()
+
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+	if e = ctx.Err(); e != nil {
+		return 0, e
+	}
+	stop := watch(ctx, &self.pipe.abort_write)
+	defer func() {
+		if stop() {
+			e = ctx.Err()
+		}
+	}()
+	return self.write(data, newTimeout(self.WriteTimeout()))
+}
+
+// ReadUntilContext is ReadUntil() that returns ctx.Err() and whatever has
+// been read so far once ctx is done.
+func (self *Port) ReadUntilContext(ctx context.Context, ends []string) (s string, e error) {
+	self.rlock.Lock(); defer self.rlock.Unlock()
+
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+	if e = ctx.Err(); e != nil {
+		return "", e
+	}
+	stop := watch(ctx, &self.pipe.abort_read)
+	defer func() {
+		if stop() {
+			e = ctx.Err()
+		}
+	}()
+	return self.read_until(ends, newTimeout(self.ReadTimeout()))
+}
+
+/* EOF */
@@ -0,0 +1,83 @@
+package sio
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// struct serial_icounter_struct, see linux/serial.h
+type serial_icounter_struct struct {
+	cts, dsr, rng, dcd int32
+	rx, tx int32
+	frame, overrun, parity, brk int32
+	buf_overrun int32
+	reserved [9]int32
+}
+
+// LineCounters are the driver's traffic and error counters.
+// They only grow (and wrap around) while the device exists.
+type LineCounters struct {
+	Time time.Time // when the snapshot was taken
+	Rx, Tx uint32
+	Frame, Parity, Overrun, Brk, Buf_overrun uint32
+	Cts, Dsr, Rng, Dcd uint32 // modem line transitions
+}
+
+func (fd *Ioctl) TIOCGICOUNT() (icount serial_icounter_struct, e error) {
+	_, _, err := fd.ioctl(syscall.TIOCGICOUNT, uintptr(unsafe.Pointer(&icount)))
+	if err != E_OK {
+		return icount, NewPortError("ioctl(%v, TIOCGICOUNT, *): %v", *fd, err)
+	}
+	return icount, nil
+}
+
+func (self *Port) LineCounters() (counters LineCounters, e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return counters, PortNotOpenError; }
+	icount, e := self.fd.TIOCGICOUNT()
+	if e != nil {
+		return counters, e
+	}
+	return LineCounters{
+		Time: time.Now(),
+		Rx: uint32(icount.rx),
+		Tx: uint32(icount.tx),
+		Frame: uint32(icount.frame),
+		Parity: uint32(icount.parity),
+		Overrun: uint32(icount.overrun),
+		Brk: uint32(icount.brk),
+		Buf_overrun: uint32(icount.buf_overrun),
+		Cts: uint32(icount.cts),
+		Dsr: uint32(icount.dsr),
+		Rng: uint32(icount.rng),
+		Dcd: uint32(icount.dcd),
+	}, nil
+}
+
+// Delta is what has happened since prev and how long it took;
+// uint32 arithmetic takes care of the counters wrapping around.
+func (self LineCounters) Delta(prev LineCounters) (delta LineCounters, elapsed time.Duration) {
+	return LineCounters{
+		Time: self.Time,
+		Rx: self.Rx - prev.Rx,
+		Tx: self.Tx - prev.Tx,
+		Frame: self.Frame - prev.Frame,
+		Parity: self.Parity - prev.Parity,
+		Overrun: self.Overrun - prev.Overrun,
+		Brk: self.Brk - prev.Brk,
+		Buf_overrun: self.Buf_overrun - prev.Buf_overrun,
+		Cts: self.Cts - prev.Cts,
+		Dsr: self.Dsr - prev.Dsr,
+		Rng: self.Rng - prev.Rng,
+		Dcd: self.Dcd - prev.Dcd,
+	}, self.Time.Sub(prev.Time)
+}
+
+// Errors is the sum of all the error counters
+func (self LineCounters) Errors() uint32 {
+	return self.Frame + self.Parity + self.Overrun + self.Buf_overrun
+}
+
+/* EOF */
@@ -0,0 +1,153 @@
+package sio
+
+import (
+	"syscall"
+	"time"
+)
+
+// Linux has no DSR/DTR flow control in the tty layer, so PortConfig.DsrDtr
+// is done here: the write path holds off while DSR is low and a goroutine
+// drops DTR while the input queue is above the high water mark.
+// DSR is polled with TIOCMGET rather than waited for with TIOCMIWAIT:
+// a pending TIOCMIWAIT keeps the tty open past Close().
+
+// DsrDtrOptions tunes the DSR/DTR handshake
+type DsrDtrOptions struct {
+	Chunk int // bytes written between DSR checks
+	HighWater, LowWater uint32 // input queue levels to drop/raise DTR at
+	Poll time.Duration // how often DSR and the input queue are looked at
+}
+
+var DefaultDsrDtrOptions = DsrDtrOptions{
+	Chunk: 16,
+	HighWater: 3072,
+	LowWater: 1024,
+	Poll: 10 * time.Millisecond,
+}
+
+func (self *DsrDtrOptions) Validate() error {
+	if self.Chunk <= 0 || self.Poll <= 0 || self.LowWater >= self.HighWater {
+		return NewPortError("PortConfig: invalid DSR/DTR options %+v", *self)
+	}
+	return nil
+}
+
+// wait_dsr returns once DSR is up, on timeout or on CancelWrite()
+func (self *Port) wait_dsr(tmo *timeout) (e error) {
+	for {
+		tiocm, e := self.fd.TIOCMGET()
+		assert(e, "dsrdtr")
+		if tiocm & syscall.TIOCM_DSR != 0 {
+			return nil
+		}
+		if tmo.expired() {
+			return PortTimeoutError
+		}
+		wait := self.dsrdtr_options.Poll
+		if !tmo.isInfinite() && tmo.left() < wait {
+			wait = tmo.left()
+		}
+		fds := []pollFd{self.pipe.abort_write.pollRead()}
+		n, e := poll2(fds, wait.Seconds())
+		assert(e, "poll")
+		if n > 0 {
+			assert(self.pipe.abort_write.Fetch(),
+				"read(pipe.abort_write.r)")
+			return PortCancelledError
+		}
+	}
+}
+
+// write_dsrdtr is write() in chunks, each one drained before DSR is
+// looked at again, so at most Chunk bytes go out after DSR drops.
+// The drain is within tmo and CancelWrite() stops it too.
+func (self *Port) write_dsrdtr(data []byte, tmo *timeout) (sent int, e error) {
+	chunk := self.dsrdtr_options.Chunk
+	for sent < len(data) {
+		e = self.wait_dsr(tmo)
+		if e == PortTimeoutError && tmo.isNonBlocking() {
+			return sent, nil
+		}
+		if e != nil {
+			return sent, e
+		}
+		end := sent + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		var n int
+		n, e = self.write_some(data[sent:end], tmo)
+		sent += n
+		if e != nil || sent < end {
+			return sent, e // non-blocking and the queue is full
+		}
+		e = self.wait_sent(tmo, &self.pipe.abort_write)
+		if e == PortTimeoutError && tmo.isNonBlocking() {
+			return sent, nil
+		}
+		if e != nil {
+			return sent, e
+		}
+	}
+	return sent, nil
+}
+
+// dsrdtr_step raises or drops DTR by the input queue level
+func (self *Port) dsrdtr_step() (e error) {
+	if !self.IsOpen() { return PortNotOpenError; }
+	tiocm, e := self.fd.TIOCMGET()
+	if e != nil { return e; }
+	queued, e := self.fd.TIOCINQ()
+	if e != nil { return e; }
+
+	dtr := tiocm & syscall.TIOCM_DTR != 0
+	switch {
+	case dtr && queued >= self.dsrdtr_options.HighWater:
+		return self.fd.SetDTR(false)
+	case !dtr && queued <= self.dsrdtr_options.LowWater:
+		return self.fd.SetDTR(true)
+	}
+	return nil
+}
+
+// start_dsrdtr and stop_dsrdtr are called with self.lock held
+func (self *Port) start_dsrdtr() (e error) {
+	if self.dsrdtr_stop != nil {
+		return nil
+	}
+	e = self.dsrdtr_step()
+	if e != nil { return e; }
+
+	stop, closed := make(chan bool), self.closed
+	self.dsrdtr_stop = stop
+	poll := self.dsrdtr_options.Poll
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <- ticker.C:
+			case <- stop:
+				return
+			case <- closed:
+				return
+			}
+			self.lock.Lock()
+			e := self.dsrdtr_step()
+			self.lock.Unlock()
+			if e != nil {
+				return // closed, or the driver has no modem lines
+			}
+		}
+	}()
+	return nil
+}
+
+func (self *Port) stop_dsrdtr() {
+	if self.dsrdtr_stop != nil {
+		close(self.dsrdtr_stop)
+		self.dsrdtr_stop = nil
+	}
+}
+
+/* EOF */
@@ -26,6 +26,8 @@ func (self *PortError) Set(e error, m string) {
 
 var PortNotOpenError = NewPortError("Port was not open")
 var PortTimeoutError = NewPortError("Port timed out")
+var PortCancelledError = NewPortError("Port operation cancelled")
+var Rs485NotSupportedError = NewPortError("RS485 is not supported by the driver")
 
 func NewPortError(message string, args ...interface{}) *PortError {
 	var pe *PortError = &PortError{}
@@ -2,7 +2,8 @@ package sio
 
 import "syscall"
 import "unsafe"
-import "fmt"
+import "time"
+import "runtime"
 
 const (
 	TCOOFF	= 0
@@ -34,12 +35,61 @@ func (fd *Ioctl) SendBreak() (e error) {
 	return nil
 }
 
+// SetBreak starts (TIOCSBRK) or stops (TIOCCBRK) sending a BREAK
+func (fd *Ioctl) SetBreak(on bool) (e error) {
+	var tag = "TIOCCBRK"
+	var command = syscall.TIOCCBRK
+	if on {
+		tag = "TIOCSBRK"
+		command = syscall.TIOCSBRK
+	}
+	_, _, err := fd.ioctl(command, 0)
+	if err != E_OK {
+		return NewPortError("ioctl(%v, %s): %v", *fd, tag, err)
+	}
+	return nil
+}
+
 func (fd *Ioctl) TcDrain() (e error) {
 	e = tcDrain(*fd)
 	if e != nil { return e; }
 	return nil
 }
 
+// DrainPoll is how often wait_sent() looks at the output queue
+const DrainPoll = 10 * time.Millisecond
+
+// wait_sent is tcdrain(3) that gives up on tmo or on the abort pipe, if
+// there is one, with PortTimeoutError or PortCancelledError. It watches
+// TIOCOUTQ, so what the UART's FIFO holds is not waited for.
+func (self *Port) wait_sent(tmo *timeout, abort *servicePipe) (e error) {
+	for {
+		queued, e := self.fd.TIOCOUTQ()
+		if e != nil { return e; }
+		if queued == 0 {
+			return nil
+		}
+		if tmo.expired() {
+			return PortTimeoutError
+		}
+		wait := DrainPoll
+		if !tmo.isInfinite() && tmo.left() < wait {
+			wait = tmo.left()
+		}
+		var fds []pollFd
+		if abort != nil {
+			fds = append(fds, abort.pollRead())
+		}
+		n, e := poll2(fds, wait.Seconds())
+		if e != nil { return e; }
+		if n > 0 {
+			e = abort.Fetch()
+			if e != nil { return e; }
+			return PortCancelledError
+		}
+	}
+}
+
 func (fd *Ioctl) InWaiting() (cnt uint32, e error) {
 	defer func() {
 		if state := recover(); state != nil {
@@ -78,51 +128,20 @@ func (fd *Ioctl) OutWaiting() (cnt uint32, e error) {
 }
 
 func (fd *Ioctl) TIOCMGET() (tiocm uint32, e error) {
-	const tag = "TIOCMGET"
-	const command int = syscall.TIOCMGET // FIXME: it just doesn't work
-
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
 
-	var data [2]uint32
-
-	// r1, r2, err := fd.ioctl(command, uintptr(unsafe.Pointer(&data)))
-	fmt.Println(	"sys=", syscall.SYS_IOCTL,
-			"fd=",		uintptr(*fd),
-			"call=",	uintptr(syscall.TIOCMGET),
-			"data=",	uintptr(unsafe.Pointer(&data)))
-	r1, r2, err := syscall.Syscall(	syscall.SYS_IOCTL,
-					uintptr(*fd),
-					uintptr(syscall.TIOCMGET),
-					uintptr(unsafe.Pointer(&data)))
-	fmt.Println(	"r1=",	r1,
-			"r2=",	r2,
-			"err=",	err)
-	assertb(r1 != UERROR, "ioctl(%v, %s, %v): %+v", *fd, tag, &data, err)
-
-	tiocm = u32at(r2, 0)
-	return tiocm, nil
-}
-
-func (self *Port) TIOCMGET_bit(bit uint32) bool {
-	if !self.IsOpen() {
-		panic(PortNotOpenError)
+	var data [1]uint32
+	_, _, err := fd.ioctl(syscall.TIOCMGET, uintptr(unsafe.Pointer(&data)))
+	if err != E_OK {
+		return 0, NewPortError("ioctl(%v, TIOCMGET, *): %v", *fd, err)
 	}
-
-	tio, e := self.fd.TIOCMGET()
-	assert(e, "TIOCMGET_bit")
-
-	return tio & bit != 0
+	return data[0], nil
 }
 
-func (self *Port) CTS() bool { return self.TIOCMGET_bit(syscall.TIOCM_CTS); }
-func (self *Port) DSR() bool { return self.TIOCMGET_bit(syscall.TIOCM_DSR); }
-func (self *Port) RI() bool { return self.TIOCMGET_bit(syscall.TIOCM_RI); }
-func (self *Port) CD() bool { return self.TIOCMGET_bit(syscall.TIOCM_CD); }
-
 func (self *Port) SetInputFlowControl(set bool) (e error) {
 	defer func() {
 		if state := recover(); state != nil {
@@ -169,6 +188,55 @@ func (self *Port) SendBreak() (e error) {
 	return nil
 }
 
+func (self *Port) SetBreak(on bool) error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return PortNotOpenError; }
+	return self.fd.SetBreak(on)
+}
+
+// time.Sleep() may oversleep that much, the rest of a break is spun
+const BreakSpin = 2 * time.Millisecond
+
+// BreakFor sends a BREAK for d once the pending output is gone and
+// returns how long it has actually been held.
+func (self *Port) BreakFor(d time.Duration) (actual time.Duration, e error) {
+	self.wlock.Lock(); defer self.wlock.Unlock()
+
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	if !self.IsOpen() { return 0, PortNotOpenError; }
+	e = self.fd.TcDrain()
+	assert(e, "BreakFor: drain")
+
+	runtime.LockOSThread(); defer runtime.UnlockOSThread()
+
+	e = self.fd.SetBreak(true)
+	assert(e, "BreakFor: %w", e)
+	t0 := time.Now()
+	on := true
+	defer func() {
+		if on { // one more go: the line must not stay in BREAK
+			self.fd.SetBreak(false)
+		}
+	}()
+	if d > BreakSpin {
+		time.Sleep(d - BreakSpin)
+	}
+	for time.Since(t0) < d {
+		// spin
+	}
+	e = self.fd.SetBreak(false)
+	actual = time.Since(t0)
+	assert(e, "BreakFor: %w", e)
+	on = false
+	return actual, nil
+}
+
 func (self *Port) Drain() (e error) {
 	defer func() {
 		if state := recover(); state != nil {
@@ -0,0 +1,77 @@
+package sio
+
+import "time"
+
+// DefaultFrameGap is the Modbus RTU idle time between frames, in characters
+const DefaultFrameGap = 3.5
+
+func (self *Port) InterByteTimeout() time.Duration {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	return self.inter_byte_timeout
+}
+
+// CharTime is how long one character takes on the wire with the current
+// settings: start bit, data bits, parity and stop bits.
+func (self *Port) CharTime() time.Duration {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	return self.char_time()
+}
+
+func (self *Port) char_time() time.Duration {
+	if self.speed == BIT_RATE_B0 {
+		return 0
+	}
+	bits := 1 + uint(self.char_size) + uint(self.stop_bits)
+	if self.parity != PARITY_NONE {
+		bits++
+	}
+	return time.Duration(bits) * time.Second / time.Duration(self.speed)
+}
+
+// FrameGap is how long the line must be idle for ReadFrame() to return:
+// InterByteTimeout() if set, DefaultFrameGap characters otherwise.
+func (self *Port) FrameGap() time.Duration {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if self.inter_byte_timeout > 0 {
+		return self.inter_byte_timeout
+	}
+	return time.Duration(float64(self.char_time()) * DefaultFrameGap)
+}
+
+// ReadFrame waits up to ReadTimeout() for the first byte, then reads until
+// the line is idle for FrameGap() or max bytes are there.
+// The gap is timed by poll(2) in nanoseconds, not by VTIME.
+func (self *Port) ReadFrame(max int) (frame []byte, e error) {
+	self.rlock.Lock(); defer self.rlock.Unlock()
+
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	var n int
+	buf := make([]byte, max)
+	n, e = self.read_some(buf, newTimeout(self.ReadTimeout()))
+	if e != nil || n == 0 {
+		return nil, e
+	}
+	gap := self.FrameGap()
+	for n < max {
+		var m int
+		m, e = self.read_some(buf[n:], newTimeout(gap))
+		n += m
+		if e == PortTimeoutError {
+			break // idle: end of frame
+		}
+		if e != nil {
+			return buf[:n], e
+		}
+	}
+	return buf[:n], nil
+}
+
+/* EOF */
@@ -0,0 +1,265 @@
+package sio
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// UeventSource delivers kernel uevent messages, one per call:
+// "add@/devices/...\0ACTION=add\0SUBSYSTEM=tty\0DEVNAME=ttyUSB0\0..."
+// ReadUevent fails once Close() has been called; UeventsLostError is
+// not a failure, there is more to read after it.
+type UeventSource interface {
+	ReadUevent() ([]byte, error)
+	Close() error
+}
+
+var WatcherClosedError = NewPortError("Watcher was closed")
+var UeventsLostError = NewPortError("uevents were lost")
+
+// netlinkSource is the kernel's own multicast group, not udev's:
+// the /dev node may not be there yet when "add" comes
+type netlinkSource struct {
+	fd Ioctl
+	pipe servicePipe
+	lock sync.Mutex // fd and pipe go away under it
+}
+
+func NewNetlinkSource() (source UeventSource, e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	fd, e := syscall.Socket(syscall.AF_NETLINK,
+				syscall.SOCK_DGRAM | syscall.SOCK_CLOEXEC,
+				syscall.NETLINK_KOBJECT_UEVENT)
+	assert(e, "socket(NETLINK_KOBJECT_UEVENT)")
+	self := &netlinkSource{fd: Ioctl(fd)}
+	e = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: 1, // kernel events
+	})
+	if e != nil {
+		syscall.Close(fd)
+		assert(e, "bind(NETLINK_KOBJECT_UEVENT)")
+	}
+	e = self.pipe.Open()
+	if e != nil {
+		syscall.Close(fd)
+		assert(e, "pipe(netlink)")
+	}
+	return self, nil
+}
+
+// ReadUevent waits in poll(2) so Close() can wake it up. The socket is
+// let go of here, by the reader, once that happens or anything fails.
+func (self *netlinkSource) ReadUevent() (msg []byte, e error) {
+	buf := make([]byte, 64 * 1024)
+	for {
+		if self.fd == ZeroIoctl {
+			return nil, WatcherClosedError
+		}
+		fds := []pollFd{self.fd.pollFd(POLLIN), self.pipe.pollRead()}
+		_, e = poll2(fds, NoSelectTimeout)
+		if e != nil {
+			self.release()
+			return nil, e
+		}
+		if fds[1].ready() {
+			self.release()
+			return nil, WatcherClosedError
+		}
+		n, _, e := syscall.Recvfrom(int(self.fd), buf, 0)
+		if e != nil && ignorable(e) {
+			continue
+		}
+		if e == syscall.ENOBUFS { // a burst has overrun the socket
+			return nil, UeventsLostError
+		}
+		if e != nil {
+			self.release()
+			return nil, e
+		}
+		return buf[:n], nil
+	}
+}
+
+func (self *netlinkSource) release() {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	syscall.Close(int(self.fd))
+	self.fd = ZeroIoctl
+	self.pipe.Close()
+}
+
+func (self *netlinkSource) Close() (e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if self.fd == ZeroIoctl {
+		return nil // released already
+	}
+	return self.pipe.Notify() // the pipe is full long before it blocks
+}
+
+// parseUevent splits the message into its KEY=value pairs; the
+// "action@devpath" header is dropped, ACTION and DEVPATH repeat it
+func parseUevent(msg []byte) map[string]string {
+	uevent := make(map[string]string)
+	for _, field := range bytes.Split(msg, []byte{0}) {
+		if i := bytes.IndexByte(field, '='); i > 0 {
+			uevent[string(field[:i])] = string(field[i + 1:])
+		}
+	}
+	return uevent
+}
+
+type HotplugAction uint
+const (
+	HOTPLUG_ADD = HotplugAction(1)
+	HOTPLUG_REMOVE = HotplugAction(2)
+	HOTPLUG_RESYNC = HotplugAction(3) // events were lost, see ListPorts()
+)
+
+func (self HotplugAction) String() string {
+	switch self {
+	case HOTPLUG_ADD: return "add"
+	case HOTPLUG_REMOVE: return "remove"
+	case HOTPLUG_RESYNC: return "resync"
+	}
+	return "HotplugAction(" + strconv.Itoa(int(self)) + ")"
+}
+
+type HotplugEvent struct {
+	Time time.Time
+	Action HotplugAction
+	Port PortInfo
+}
+
+// hotplugEvent turns a tty add/remove uevent into an event. On "add" the
+// sysfs directory is looked at like ListPorts() does, and virtual ttys
+// are dropped; on "remove" it is gone and only the uevent is there.
+func hotplugEvent(uevent map[string]string) (event HotplugEvent, ok bool) {
+	if uevent["SUBSYSTEM"] != SysfsClass || uevent["DEVNAME"] == "" {
+		return event, false
+	}
+	switch uevent["ACTION"] {
+	case "add":
+		event.Action = HOTPLUG_ADD
+	case "remove":
+		event.Action = HOTPLUG_REMOVE
+	default:
+		return event, false
+	}
+	event.Time = time.Now()
+
+	info := &event.Port
+	info.Name = filepath.Base(uevent["DEVNAME"])
+	info.Device = filepath.Join(DevRoot, uevent["DEVNAME"])
+	info.SysFS = filepath.Join(SysfsRoot, uevent["DEVPATH"])
+	info.Description = info.Name
+	major, e := strconv.ParseUint(uevent["MAJOR"], 10, 32)
+	if e == nil {
+		info.Major = major
+	}
+	minor, e := strconv.ParseUint(uevent["MINOR"], 10, 32)
+	if e == nil {
+		info.Minor = minor
+	}
+
+	if event.Action == HOTPLUG_ADD && exists(info.SysFS) {
+		found, real := portInfo(DevRoot, info.SysFS)
+		if !real {
+			return event, false
+		}
+		found.Device = info.Device // DEVNAME may have a subdirectory
+		*info = found
+	}
+	return event, true
+}
+
+// Watcher reports serial ports coming and going. Removes are reported
+// for every tty, as there is nothing left to tell a real one by.
+// HOTPLUG_RESYNC says some were missed: ListPorts() has what is there.
+type Watcher struct {
+	source UeventSource
+	events chan HotplugEvent
+	done chan bool
+	once sync.Once
+}
+
+// NewWatcher listens to the kernel's uevents
+func NewWatcher() (*Watcher, error) {
+	source, e := NewNetlinkSource()
+	if e != nil {
+		return nil, e
+	}
+	return NewWatcherWithSource(source), nil
+}
+
+// NewWatcherWithSource takes uevents from source; the Watcher closes it
+func NewWatcherWithSource(source UeventSource) *Watcher {
+	self := &Watcher{
+		source: source,
+		events: make(chan HotplugEvent),
+		done: make(chan bool),
+	}
+	go self.run()
+	return self
+}
+
+func (self *Watcher) run() {
+	defer close(self.events)
+	for {
+		var event HotplugEvent
+		msg, e := self.source.ReadUevent()
+		switch {
+		case e == UeventsLostError:
+			event = HotplugEvent{Time: time.Now(), Action: HOTPLUG_RESYNC}
+		case e != nil:
+			return
+		default:
+			var ok bool
+			event, ok = hotplugEvent(parseUevent(msg))
+			if !ok {
+				continue
+			}
+		}
+		select {
+		case self.events <- event:
+		case <- self.done:
+			self.drain()
+			return
+		}
+	}
+}
+
+// drain reads until the source fails, as it does once closed:
+// netlinkSource lets go of its socket only in ReadUevent
+func (self *Watcher) drain() {
+	for {
+		if _, e := self.source.ReadUevent(); e != nil && e != UeventsLostError {
+			return
+		}
+	}
+}
+
+// Events is closed once the Watcher is closed or the source fails
+func (self *Watcher) Events() <-chan HotplugEvent {
+	return self.events
+}
+
+func (self *Watcher) Close() (e error) {
+	self.once.Do(func() {
+		close(self.done)
+		e = self.source.Close()
+	})
+	return e
+}
+
+/* EOF */
@@ -10,9 +10,20 @@ const UERROR = uintptr(18446744073709551615)
 type Ioctl uintptr
 
 const ZeroIoctl = Ioctl(0)
-const ASYNC_LOW_LATENCY uint32 = 0x2000
 const E_OK = syscall.Errno(0)
 
+// linux, generic (not alpha, mips, powerpc or sparc)
+const TCGETS2 = 0x802c542a
+const TCSETS2 = 0x402c542b
+
+// Termios2 is the kernel's struct termios2 with explicit speeds
+type Termios2 struct {
+	Iflag, Oflag, Cflag, Lflag uint32
+	Line uint8
+	Cc [19]uint8
+	Ispeed, Ospeed uint32
+}
+
 func (fd *Ioctl) Set(value uintptr) {
 	*fd = Ioctl(value)
 }
@@ -53,16 +64,17 @@ func (fd *Ioctl) GETFL() (flags uintptr, e error) {
 
 func (fd *Ioctl) NonBlock(set bool) (e error) {
 	fl, e := fd.GETFL()
+	if e != nil { return e; }
 	if set {
 		fl |= syscall.O_NONBLOCK
 	} else {
 		fl &= ^uintptr(syscall.O_NONBLOCK)
 	}
 
-	var buf [1]uint32
-	buf[0] = uint32(fl)
-	_, _, err := fd.fcntl(syscall.F_SETFL, uintptr(unsafe.Pointer(&buf)))
-	assertb(err == E_OK, "F_SETFL")
+	_, _, err := fd.fcntl(syscall.F_SETFL, fl) // the flags, not a pointer
+	if err != E_OK {
+		return NewPortError("fcntl(%v, F_SETFL, %#x): %v", fd, fl, err)
+	}
 	return nil
 }
 
@@ -100,84 +112,114 @@ func (fd *Ioctl) TIOCINQ() (count uint32, e error) {
 	return
 }
 
-func (fd *Ioctl) get_low_latency_mode() (mode bool, e error) {
+func (fd *Ioctl) TcGetAttr() (termios syscall.Termios, e error) {
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
 
-	var buf [32]uint32
-	_, _, err := fd.ioctl(syscall.TIOCGSERIAL, uintptr(unsafe.Pointer(&buf)))
-	if err != E_OK {
-		return false, NewPortError("ioctl(%v, TIOCGSERIAL, *): %v", fd, err)
-	}
-	return buf[4] & ASYNC_LOW_LATENCY == ASYNC_LOW_LATENCY, nil
+	_, _, err := fd.ioctl(syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	assertb(err == E_OK, "ioctl(%v, TCGETS, *): %v", fd, err)
+	return termios, nil
 }
-func (fd *Ioctl) set_low_latency_mode(set bool) (e error) {
+func (fd *Ioctl) TcSetAttr(termios syscall.Termios) (e error) {
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
 
-	var buf [32]uint32
-	_, _, err := fd.ioctl(syscall.TIOCGSERIAL, uintptr(unsafe.Pointer(&buf)))
-	assertb(err == E_OK, "ioctl(%v, TIOCGSERIAL, *): %v", fd, err)
-	if set {
-		buf[4] |= ASYNC_LOW_LATENCY
-	} else {
-		buf[4] &= ^ASYNC_LOW_LATENCY
-	}
-	_, _, err = fd.ioctl(syscall.TIOCSSERIAL, uintptr(unsafe.Pointer(&buf)))
-	assertb(err == E_OK, "ioctl(%v, TIOCSSERIAL, *): %v", fd, err)
+	_, _, err := fd.ioctl(syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	assertb(err == E_OK, "ioctl(%v, TCSETS, *): %v", fd, err)
 	return nil
 }
 
-func (fd *Ioctl) TcGetAttr() (termios syscall.Termios, e error) {
+func (fd *Ioctl) TcGetAttr2() (termios Termios2, e error) {
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
 
-	_, _, err := fd.ioctl(syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
-	assertb(err == E_OK, "ioctl(%v, TCGETS, *): %v", fd, err)
+	_, _, err := fd.ioctl(TCGETS2, uintptr(unsafe.Pointer(&termios)))
+	assertb(err == E_OK, "ioctl(%v, TCGETS2, *): %v", fd, err)
 	return termios, nil
 }
-func (fd *Ioctl) TcSetAttr(termios syscall.Termios) (e error) {
+func (fd *Ioctl) TcSetAttr2(termios Termios2) (e error) {
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
 
-	_, _, err := fd.ioctl(syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
-	assertb(err == E_OK, "ioctl(%v, TCSETS, *): %v", fd, err)
+	_, _, err := fd.ioctl(TCSETS2, uintptr(unsafe.Pointer(&termios)))
+	assertb(err == E_OK, "ioctl(%v, TCSETS2, *): %v", fd, err)
 	return nil
 }
 
-func (fd *Ioctl) TIOCGRS485(buf *[8]uint32) (e error) {
+// TcSetSpeed sets arbitrary input and output speeds via BOTHER.
+// Zero input speed means "same as output".
+func (fd *Ioctl) TcSetSpeed(ispeed, ospeed BitRate) (e error) {
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
 
-	_, _, err := fd.ioctl(syscall.TIOCGRS485, uintptr(unsafe.Pointer(buf)))
-	assertb(err == E_OK, "ioctl(%v, TIOCGRS485, *): %v", fd, err)
+	termios, e := fd.TcGetAttr2()
+	assert(e, "TCGETS2")
+
+	termios.Cflag &= ^uint32(CBAUD | CIBAUD)
+	termios.Cflag |= BOTHER
+	termios.Ospeed = uint32(ospeed)
+	if ispeed != BIT_RATE_B0 {
+		termios.Cflag |= BOTHER << IBSHIFT
+		termios.Ispeed = uint32(ispeed)
+	} else {
+		termios.Ispeed = uint32(ospeed)
+	}
+
+	e = fd.TcSetAttr2(termios)
+	assert(e, "TCSETS2")
 	return nil
 }
-func (fd *Ioctl) TIOCSRS485(buf [8]uint32) (e error) {
-	defer func() {
-		if state := recover(); state != nil {
-			e = WrapError(state.(error))
-		}
-	}()
 
-	_, _, err := fd.ioctl(syscall.TIOCSRS485, uintptr(unsafe.Pointer(&buf)))
-	assertb(err == E_OK, "ioctl(%v, TIOCSRS485, *): %v", fd, err)
-	return nil
+// TcGetSpeed returns the speeds the driver has actually applied
+func (fd *Ioctl) TcGetSpeed() (ispeed, ospeed BitRate, e error) {
+	termios, e := fd.TcGetAttr2()
+	if e != nil { return 0, 0, e; }
+	return BitRate(termios.Ispeed), BitRate(termios.Ospeed), nil
+}
+
+// TIOCGRS485 returns Rs485NotSupportedError if the driver knows nothing of it
+func (fd *Ioctl) TIOCGRS485() (rs serial_rs485, e error) {
+	_, _, err := fd.ioctl(syscall.TIOCGRS485, uintptr(unsafe.Pointer(&rs)))
+	switch err {
+	case E_OK:
+		return rs, nil
+	case syscall.ENOTTY:
+		return rs, Rs485NotSupportedError
+	}
+	return rs, NewPortError("ioctl(%v, TIOCGRS485, *): %v", *fd, err)
+}
+
+// TIOCSRS485 updates rs with what the driver has actually accepted
+func (fd *Ioctl) TIOCSRS485(rs *serial_rs485) (e error) {
+	_, _, err := fd.ioctl(syscall.TIOCSRS485, uintptr(unsafe.Pointer(rs)))
+	switch err {
+	case E_OK:
+		return nil
+	case syscall.ENOTTY:
+		return Rs485NotSupportedError
+	}
+	return NewPortError("ioctl(%v, TIOCSRS485, *): %v", *fd, err)
+}
+
+func (fd *Ioctl) GetRs485() (rs485 Rs485, e error) {
+	rs, e := fd.TIOCGRS485()
+	if e != nil { return rs485, e; }
+	return newRs485(rs), nil
 }
 
 func (fd *Ioctl) SetRs485(rs485 Rs485) (e error) {
@@ -187,16 +229,21 @@ func (fd *Ioctl) SetRs485(rs485 Rs485) (e error) {
 		}
 	}()
 
-	var buf [8]uint32
-
-	e = fd.TIOCGRS485(&buf)
+	rs, e := fd.TIOCGRS485()
 	assert(e, "TIOCGRS485")
-	rs485.Update(&buf)
-	e = fd.TIOCSRS485(buf)
+	rs485.update(&rs)
+	e = fd.TIOCSRS485(&rs)
 	assert(e, "TIOCSRS485")
 	return nil
 }
 
+func (fd *Ioctl) SetExclusive(exclusive bool) (e error) {
+	if exclusive {
+		return fd.Flock(syscall.LOCK_EX | syscall.LOCK_NB)
+	}
+	return fd.Flock(syscall.LOCK_UN)
+}
+
 func (fd *Ioctl) Reconfigure(termios *syscall.Termios, port *Port) (e error) {
 	// https://github.com/pyserial/pyserial/blob/master/serial/serialposix.py
 	defer func() {
@@ -205,27 +252,40 @@ func (fd *Ioctl) Reconfigure(termios *syscall.Termios, port *Port) (e error) {
 		}
 	}()
 
-	if port.exclusive {
-		assert(fd.Flock(syscall.LOCK_EX | syscall.LOCK_NB),
-			"Flock(LOCK_EX|LOCK_NB)")
-	} else {
-		assert(fd.Flock(syscall.LOCK_UN), "Flock(LOCK_UN)")
-	}
+	assert(fd.SetExclusive(port.exclusive), "SetExclusive(%v)", port.exclusive)
 
 	*termios, e = fd.TcGetAttr()
 	assert(e, "TCGETATTR")
 
-	var trms syscall.Termios = *termios
+	e = fd.Apply(*termios, port)
+	assert(e, "Apply")
+
+	return nil
+}
+
+// Apply builds new settings for the port on top of termios and sets them
+func (fd *Ioctl) Apply(termios syscall.Termios, port *Port) (e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
 
-	e = setTermios(&trms, port)
+	e = setTermios(&termios, port)
 	assert(e, "setTermios")
 
-	e = fd.TcSetAttr(trms)
+	e = fd.TcSetAttr(termios)
 	assert(e, "TCSETATTR")
 
+	if needsTermios2(port.ispeed, port.speed) {
+		e = fd.TcSetSpeed(port.ispeed, port.speed)
+		assert(e, "TcSetSpeed(%v, %v): %w", port.ispeed, port.speed, e)
+	}
+
+	port.rs485_soft = false
 	if port.rs485.Enabled {
-		e = fd.SetRs485(port.rs485)
-		assert(e, "fd.set_rs485(%+v): %w", port.rs485, e)
+		port.rs485_soft, e = fd.useRs485(port.rs485)
+		assert(e, "SetRs485")
 	}
 
 	return nil
@@ -0,0 +1,193 @@
+package sio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DevRoot = "/dev"
+
+// PortInfo describes a serial device found by ListPorts() or Port.Info()
+type PortInfo struct {
+	Device string // /dev/ttyUSB0
+	Name string // ttyUSB0
+	SysFS string // the tty's directory under /sys/devices
+	Driver string // of the hardware, not of the tty
+	Major, Minor uint64
+	Description string
+	Usb bool // the rest is only there for USB adapters
+	VID, PID uint16
+	Serial_number, Manufacturer, Product string
+	Location string // bus-port.port, like "1-1.2"
+	Interface int // bInterfaceNumber
+	By_id, By_path []string // see StableNames
+}
+
+func (self PortInfo) String() string {
+	name := self.Device
+	if stable := stableName(self.By_id, self.By_path); stable != "" {
+		name += " aka " + stable
+	}
+	if self.Usb {
+		return fmt.Sprintf("%s [%d:%d] %s %s", name, self.Major, self.Minor,
+				   self.Description, self.HWID())
+	}
+	return fmt.Sprintf("%s [%d:%d] %s", name, self.Major, self.Minor, self.Description)
+}
+
+// HWID looks like pyserial's: "USB VID:PID=0403:6001 SER=A50285BI LOCATION=1-1.2:1.0"
+func (self PortInfo) HWID() string {
+	if !self.Usb {
+		return ""
+	}
+	hwid := fmt.Sprintf("USB VID:PID=%04x:%04x", self.VID, self.PID)
+	if self.Serial_number != "" {
+		hwid += " SER=" + self.Serial_number
+	}
+	return hwid + fmt.Sprintf(" LOCATION=%s:1.%d", self.Location, self.Interface)
+}
+
+// ListPorts is pyserial's list_ports.comports(): the ttys in /sys/class/tty
+// with hardware behind them. Virtual consoles and ptys have no "device",
+// 8250 ports nobody has found a UART for have "type" 0.
+func ListPorts() (ports []PortInfo, e error) {
+	return listPorts(SysfsRoot, DevRoot)
+}
+
+func listPorts(sysfs, dev string) (ports []PortInfo, e error) {
+	class := filepath.Join(sysfs, "class", SysfsClass)
+	entries, e := ioutil.ReadDir(class)
+	if e != nil {
+		return nil, e
+	}
+	for _, entry := range entries {
+		info, ok := portInfo(dev, filepath.Join(class, entry.Name()))
+		if !ok {
+			continue
+		}
+		st, e := os.Stat(info.Device)
+		if e != nil || !isCharDevice(st) {
+			continue
+		}
+		if major, minor := GetDeviceNumber(st); major != info.Major || minor != info.Minor {
+			continue // a stale node in /dev
+		}
+		ports = append(ports, info)
+	}
+	return ports, nil
+}
+
+// portInfo looks at a tty's sysfs directory, the device is taken to be
+// in dev; ok is false for what is not a real serial port, info has what
+// could be found anyway
+func portInfo(dev, path string) (info PortInfo, ok bool) {
+	path, e := filepath.EvalSymlinks(path)
+	if e != nil {
+		return info, false
+	}
+	info.Name = filepath.Base(path)
+	info.Device = filepath.Join(dev, info.Name)
+	info.SysFS = path
+	info.Description = info.Name
+	info.Major, info.Minor, e = readDevFile(filepath.Join(path, "dev"))
+	if e != nil {
+		return info, false
+	}
+
+	device, e := filepath.EvalSymlinks(filepath.Join(path, "device"))
+	if e != nil {
+		return info, false // virtual
+	}
+	if readAttr(path, "type") == "0" { // PORT_UNKNOWN
+		return info, false
+	}
+	info.Driver = hardwareDriver(device)
+	info.By_id, info.By_path = stableNames(filepath.Join(dev, "serial"), info.Device)
+	info.Description = fmt.Sprintf("%s (%s)", info.Name, info.Driver)
+	info.readUsb(device)
+	if info.Usb && info.Product != "" {
+		info.Description = fmt.Sprintf("%s (%s)", info.Product, info.Driver)
+	}
+	return info, true
+}
+
+// readUsb walks up from the tty's device to the USB interface and the USB
+// device above it; ttyUSB has one more level, the usb-serial port
+func (self *PortInfo) readUsb(device string) {
+	for dir := device; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if linkBase(filepath.Join(dir, "subsystem")) != "usb" {
+			continue
+		}
+		if number := readAttr(dir, "bInterfaceNumber"); number != "" {
+			n, e := strconv.ParseUint(number, 16, 8)
+			if e == nil {
+				self.Interface = int(n)
+			}
+			continue
+		}
+		vid, e := strconv.ParseUint(readAttr(dir, "idVendor"), 16, 16)
+		if e != nil {
+			return
+		}
+		pid, e := strconv.ParseUint(readAttr(dir, "idProduct"), 16, 16)
+		if e != nil {
+			return
+		}
+		self.Usb = true
+		self.VID, self.PID = uint16(vid), uint16(pid)
+		self.Serial_number = readAttr(dir, "serial")
+		self.Manufacturer = readAttr(dir, "manufacturer")
+		self.Product = readAttr(dir, "product")
+		self.Location = filepath.Base(dir)
+		return
+	}
+}
+
+// Info describes the open port the way ListPorts() does
+func (self *Port) Info() (info PortInfo, e error) {
+	if !self.IsOpen() { return info, PortNotOpenError; }
+	major, minor := self.DeviceId()
+	info, _ = portInfo(DevRoot, filepath.Join(SysfsRoot, "dev", "char",
+					  fmt.Sprintf("%d:%d", major, minor)))
+	info.Device = self.file.Name() // the kernel's name, see ResolvePath
+	info.Major, info.Minor = major, minor
+	info.By_id, info.By_path = self.StableNames()
+	return info, nil
+}
+
+// hardwareDriver skips the serial core's own "port" and "ctrl" devices
+// newer kernels put between the tty and the hardware
+func hardwareDriver(device string) string {
+	for dir := device; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if linkBase(filepath.Join(dir, "subsystem")) == "serial-base" {
+			continue
+		}
+		if driver := linkBase(filepath.Join(dir, "driver")); driver != "" {
+			return driver
+		}
+	}
+	return "unknown"
+}
+
+func linkBase(link string) string {
+	path, e := os.Readlink(link)
+	if e != nil {
+		return ""
+	}
+	return filepath.Base(path)
+}
+
+// readAttr returns a sysfs attribute without the newline, "" if missing
+func readAttr(dir, name string) string {
+	bytes, e := ioutil.ReadFile(filepath.Join(dir, name))
+	if e != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
+
+/* EOF */
@@ -0,0 +1,83 @@
+package sio
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// loopPort is "loop://": whatever is written comes back on read
+type loopPort struct {
+	lock sync.Mutex
+	buf []byte
+	ready chan bool
+	closed bool
+	timeout time.Duration
+}
+
+func openLoopURL(u *url.URL, cfg PortConfig) (SerialPort, error) {
+	return &loopPort{ready: make(chan bool, 1), timeout: cfg.ReadTimeout}, nil
+}
+
+func (self *loopPort) String() string {
+	return "<sio.Loop>"
+}
+
+func (self *loopPort) notify() {
+	select {
+	case self.ready <- true:
+	default: // already notified
+	}
+}
+
+func (self *loopPort) Read(data []byte) (n int, e error) {
+	tmo := newTimeout(self.timeout)
+	var expired <-chan time.Time // nil channel blocks forever
+	if !tmo.isInfinite() {
+		timer := time.NewTimer(tmo.left())
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		self.lock.Lock()
+		if self.closed {
+			self.lock.Unlock()
+			return 0, PortNotOpenError
+		}
+		if len(self.buf) > 0 {
+			n = copy(data, self.buf)
+			self.buf = self.buf[n:]
+			self.lock.Unlock()
+			return n, nil
+		}
+		self.lock.Unlock()
+
+		if tmo.isNonBlocking() {
+			return 0, nil
+		}
+		select {
+		case <- self.ready:
+		case <- expired:
+			return 0, PortTimeoutError
+		}
+	}
+}
+
+func (self *loopPort) Write(data []byte) (n int, e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if self.closed { return 0, PortNotOpenError; }
+	self.buf = append(self.buf, data...)
+	self.notify()
+	return len(data), nil
+}
+
+func (self *loopPort) Close() error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	self.closed = true
+	self.notify()
+	return nil
+}
+
+/* EOF */
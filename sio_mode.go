@@ -0,0 +1,121 @@
+package sio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SerialMode is the "115200 8N1" part of the port settings
+type SerialMode struct {
+	Speed BitRate
+	CharSize CharSize
+	Parity Parity
+	StopBits StopBits
+}
+
+const ModeSeparators = " ,/-:"
+
+var ParityLetters = map[Parity]byte{
+	PARITY_NONE: 'N',
+	PARITY_ODD: 'O',
+	PARITY_EVEN: 'E',
+	PARITY_MARK: 'M',
+	PARITY_SPACE: 'S',
+}
+
+func (self Parity) String() string {
+	if c, ok := ParityLetters[self]; ok {
+		return string(c)
+	}
+	return fmt.Sprintf("<Parity#%d>", uint(self))
+}
+
+func ParseParity(c byte) (Parity, bool) {
+	c = strings.ToUpper(string(c))[0]
+	for p, l := range ParityLetters {
+		if l == c {
+			return p, true
+		}
+	}
+	return PARITY_NONE, false
+}
+
+// ParseMode understands "115200 8N1", "9600,7E2", "19200/8-O-1",
+// "9600,8,N,1" and alike. Speed alone ("115200") means 8N1.
+func ParseMode(s string) (mode SerialMode, e error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	if i == 0 {
+		return mode, NewPortError("ParseMode(%+q): no speed", s)
+	}
+	speed, e := strconv.ParseUint(s[:i], 10, 32)
+	if e != nil || speed == 0 {
+		return mode, NewPortError("ParseMode(%+q): bad speed %+q", s, s[:i])
+	}
+	mode.Speed = BitRate(speed)
+
+	frame := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(ModeSeparators, r) {
+			return -1
+		}
+		return r
+	}, s[i:])
+	if frame == "" {
+		frame = "8N1"
+	}
+	if len(frame) != 3 {
+		return mode, NewPortError("ParseMode(%+q): bad frame %+q", s, frame)
+	}
+
+	size := int(frame[0] - '0')
+	if !IsValidCharSize(size) {
+		return mode, NewPortError("ParseMode(%+q): bad char size %q", s, frame[0])
+	}
+	mode.CharSize = CharSize(size)
+
+	parity, ok := ParseParity(frame[1])
+	if !ok || !IsValidParity(int(parity)) {
+		return mode, NewPortError("ParseMode(%+q): bad parity %q", s, frame[1])
+	}
+	mode.Parity = parity
+
+	stop := int(frame[2] - '0')
+	if !IsValidStopBits(stop) {
+		return mode, NewPortError("ParseMode(%+q): bad stop bits %q", s, frame[2])
+	}
+	mode.StopBits = StopBits(stop)
+
+	return mode, nil
+}
+
+func (self SerialMode) String() string {
+	return fmt.Sprintf("%d %d%s%d", uint32(self.Speed), uint(self.CharSize),
+			   self.Parity, uint(self.StopBits))
+}
+
+// SetMode fills in speed, char size, parity and stop bits from "115200 8N1"
+func (self *PortConfig) SetMode(s string) error {
+	mode, e := ParseMode(s)
+	if e != nil {
+		return e
+	}
+	self.Speed = mode.Speed
+	self.CharSize = mode.CharSize
+	self.Parity = mode.Parity
+	self.StopBits = mode.StopBits
+	return nil
+}
+
+func (self *PortConfig) Mode() SerialMode {
+	return SerialMode{self.Speed, self.CharSize, self.Parity, self.StopBits}
+}
+
+func (self *Port) Mode() SerialMode {
+	return SerialMode{self.speed, self.char_size, self.parity, self.stop_bits}
+}
+
+/* EOF */
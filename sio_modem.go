@@ -0,0 +1,285 @@
+package sio
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ModemLine is a TIOCM_* mask for WaitModemChange()
+type ModemLine uint32
+const (
+	MODEM_CTS = ModemLine(syscall.TIOCM_CTS)
+	MODEM_DSR = ModemLine(syscall.TIOCM_DSR)
+	MODEM_RI = ModemLine(syscall.TIOCM_RI)
+	MODEM_CD = ModemLine(syscall.TIOCM_CD)
+	MODEM_INPUTS = MODEM_CTS | MODEM_DSR | MODEM_RI | MODEM_CD
+)
+
+// LineLevel is what to do with DTR or RTS on open
+type LineLevel uint
+const (
+	LINE_KEEP = LineLevel(0) // leave it as the driver has set it
+	LINE_LOW = LineLevel(1)
+	LINE_HIGH = LineLevel(2)
+)
+
+// ModemStatus is a snapshot of the modem lines, all from one TIOCMGET
+type ModemStatus struct {
+	CTS, DSR, RI, CD bool // inputs
+	DTR, RTS bool // outputs
+}
+
+func (self ModemStatus) lines() (mask ModemLine) {
+	if self.CTS { mask |= MODEM_CTS; }
+	if self.DSR { mask |= MODEM_DSR; }
+	if self.RI { mask |= MODEM_RI; }
+	if self.CD { mask |= MODEM_CD; }
+	return mask
+}
+
+func newModemStatus(tiocm uint32) ModemStatus {
+	return ModemStatus{
+		CTS: tiocm & syscall.TIOCM_CTS != 0,
+		DSR: tiocm & syscall.TIOCM_DSR != 0,
+		RI: tiocm & syscall.TIOCM_RI != 0,
+		CD: tiocm & syscall.TIOCM_CD != 0,
+		DTR: tiocm & syscall.TIOCM_DTR != 0,
+		RTS: tiocm & syscall.TIOCM_RTS != 0,
+	}
+}
+
+// String looks like "CTS+ DSR- RI- CD- DTR+ RTS+"
+func (self ModemStatus) String() string {
+	var line = func(name string, set bool) string {
+		if set {
+			return name + "+"
+		}
+		return name + "-"
+	}
+	return strings.Join([]string{
+		line("CTS", self.CTS),
+		line("DSR", self.DSR),
+		line("RI", self.RI),
+		line("CD", self.CD),
+		line("DTR", self.DTR),
+		line("RTS", self.RTS),
+	}, " ")
+}
+
+func (self *Port) ModemStatus() (status ModemStatus, e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return status, PortNotOpenError; }
+	tiocm, e := self.fd.TIOCMGET()
+	if e != nil {
+		return status, e
+	}
+	return newModemStatus(tiocm), nil
+}
+
+// CTS, DSR, RI, CD, DTR and RTS each take a ModemStatus() of their own;
+// take one yourself to see the lines at the same moment
+func (self *Port) CTS() (bool, error) {
+	status, e := self.ModemStatus()
+	return status.CTS, e
+}
+func (self *Port) DSR() (bool, error) {
+	status, e := self.ModemStatus()
+	return status.DSR, e
+}
+func (self *Port) RI() (bool, error) {
+	status, e := self.ModemStatus()
+	return status.RI, e
+}
+func (self *Port) CD() (bool, error) {
+	status, e := self.ModemStatus()
+	return status.CD, e
+}
+func (self *Port) DTR() (bool, error) {
+	status, e := self.ModemStatus()
+	return status.DTR, e
+}
+func (self *Port) RTS() (bool, error) {
+	status, e := self.ModemStatus()
+	return status.RTS, e
+}
+
+func (self *Port) SetDTR(set bool) error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return PortNotOpenError; }
+	return self.fd.SetDTR(set)
+}
+func (self *Port) SetRTS(set bool) error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return PortNotOpenError; }
+	return self.fd.SetRTS(set)
+}
+
+// setLines sets DTR and RTS as configured, skipping those that belong
+// to flow control or to software RS485
+func (self *Port) setLines(dtr, rts LineLevel) (e error) {
+	soft_dtr := self.rs485_soft && self.rs485.Use_dtr
+	soft_rts := self.rs485_soft && !self.rs485.Use_dtr
+	if dtr != LINE_KEEP && !self.dsrdtr && !soft_dtr {
+		e = self.fd.SetDTR(dtr == LINE_HIGH)
+		if e != nil { return e; }
+	}
+	if rts != LINE_KEEP && !self.rtscts && !soft_rts {
+		e = self.fd.SetRTS(rts == LINE_HIGH)
+		if e != nil { return e; }
+	}
+	return nil
+}
+
+// TIOCMIWAIT blocks until one of the lines in mask changes
+func (fd *Ioctl) TIOCMIWAIT(mask uint32) (e error) {
+	return fd.tiocmiwait(mask, nil)
+}
+
+// tiocmiwait gives up on EINTR once stop is set, see wakeable
+func (fd *Ioctl) tiocmiwait(mask uint32, stop *int32) (e error) {
+	for !woken(stop) {
+		_, _, err := fd.ioctl(syscall.TIOCMIWAIT, uintptr(mask)) // by value
+		switch err {
+		case E_OK:
+			return nil
+		case syscall.EINTR:
+			continue
+		}
+		return NewPortError("ioctl(%v, TIOCMIWAIT, %#x): %v", *fd, mask, err)
+	}
+	return PortCancelledError
+}
+
+// modemWatch is what the next change is measured against; the driver's
+// counters catch a line that went back and forth before we looked
+type modemWatch struct {
+	status ModemStatus
+	counters LineCounters
+	has_counters bool
+}
+
+func (self *Port) modem_watch() (w modemWatch, e error) {
+	w.status, e = self.ModemStatus()
+	if e != nil { return w, e; }
+	var ce error
+	w.counters, ce = self.LineCounters()
+	w.has_counters = ce == nil // not every driver counts
+	return w, nil
+}
+
+// countersMoved tells the lines whose transition counters have moved
+func countersMoved(prev, now LineCounters) (moved ModemLine) {
+	delta, _ := now.Delta(prev)
+	if delta.Cts != 0 { moved |= MODEM_CTS; }
+	if delta.Dsr != 0 { moved |= MODEM_DSR; }
+	if delta.Rng != 0 { moved |= MODEM_RI; }
+	if delta.Dcd != 0 { moved |= MODEM_CD; }
+	return moved
+}
+
+// modemChanged is what ModemEvent.Changed says
+func modemChanged(last, now ModemStatus, moved, mask ModemLine) ModemLine {
+	return (last.lines() ^ now.lines() | moved) & mask
+}
+
+// wait_modem blocks in TIOCMIWAIT until a line in mask changes and moves
+// w on. The ioctl has a thread of its own, ctx and Close() signal it.
+func (self *Port) wait_modem(ctx context.Context, mask ModemLine, w *modemWatch) (changed ModemLine, e error) {
+	self.lock.Lock()
+	fd, closed, open := self.fd, self.closed, self.IsOpen()
+	self.lock.Unlock()
+	if !open { return 0, PortNotOpenError; }
+	if e = ctx.Err(); e != nil {
+		return 0, e
+	}
+
+	for {
+		waiter, e := startWakeable(func(stop *int32) error {
+			return fd.tiocmiwait(uint32(mask), stop)
+		})
+		if e != nil { return 0, e; }
+		select {
+		case e = <- waiter.done:
+		case <- ctx.Done():
+			waiter.wake()
+			return 0, ctx.Err()
+		case <- closed:
+			waiter.wake()
+			return 0, PortNotOpenError
+		}
+		if e != nil { return 0, e; }
+
+		status, e := self.ModemStatus()
+		if e != nil { return 0, e; }
+		var moved ModemLine
+		var counters LineCounters
+		if w.has_counters {
+			counters, e = self.LineCounters()
+			if e != nil { return 0, e; }
+			moved = countersMoved(w.counters, counters)
+		}
+		changed = modemChanged(w.status, status, moved, mask)
+		if changed != 0 {
+			w.status, w.counters = status, counters
+			return changed, nil
+		}
+	}
+}
+
+// WaitModemChange blocks until one of the mask lines changes, ctx is done
+// or the port is closed, and returns the new status.
+func (self *Port) WaitModemChange(ctx context.Context, mask ModemLine) (status ModemStatus, e error) {
+	if !self.IsOpen() { return status, PortNotOpenError; }
+	w, e := self.modem_watch()
+	if e != nil { return status, e; }
+	_, e = self.wait_modem(ctx, mask, &w)
+	if e != nil { return status, e; }
+	return w.status, nil
+}
+
+// ModemEvent is one change of the modem lines
+type ModemEvent struct {
+	Time time.Time
+	Status ModemStatus
+	Changed ModemLine // also the lines that went back and forth
+}
+
+// ModemEvents reports changes of the mask lines until ctx is done or the
+// port is closed; then the channel is closed.
+func (self *Port) ModemEvents(ctx context.Context, mask ModemLine) <-chan ModemEvent {
+	events := make(chan ModemEvent)
+	closed := self.closed
+	go func() {
+		defer close(events)
+		w, e := self.modem_watch()
+		if e != nil {
+			return
+		}
+		for {
+			changed, e := self.wait_modem(ctx, mask, &w)
+			if e != nil {
+				return
+			}
+			event := ModemEvent{
+				Time: time.Now(),
+				Status: w.status,
+				Changed: changed,
+			}
+			select {
+			case events <- event:
+			case <- ctx.Done():
+				return
+			case <- closed:
+				return
+			}
+		}
+	}()
+	return events
+}
+
+/* EOF */
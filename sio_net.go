@@ -0,0 +1,325 @@
+package sio
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// netPort is "tcp://host:port": a raw TCP serial server
+type netPort struct {
+	conn net.Conn
+	name string
+	read_timeout, write_timeout time.Duration
+}
+
+func dialPort(u *url.URL, cfg PortConfig) (*netPort, error) {
+	if u.Host == "" {
+		return nil, NewPortError("%s: no host:port", u)
+	}
+	conn, e := net.Dial("tcp", u.Host)
+	if e != nil {
+		return nil, e
+	}
+	return &netPort{
+		conn: conn,
+		name: u.Scheme + "://" + u.Host,
+		read_timeout: cfg.ReadTimeout,
+		write_timeout: cfg.WriteTimeout,
+	}, nil
+}
+
+func openTcpURL(u *url.URL, cfg PortConfig) (SerialPort, error) {
+	p, e := dialPort(u, cfg)
+	if e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+func (self *netPort) String() string {
+	return fmt.Sprintf("<sio.Net(%+q)>", self.name)
+}
+
+func netError(e error) error {
+	if os.IsTimeout(e) {
+		return PortTimeoutError
+	}
+	return e
+}
+
+// NetPollTime is what non-blocking I/O waits on a net.Conn: it fails at
+// once, data or not, if the deadline has passed already
+const NetPollTime = time.Millisecond
+
+// deadline maps the timeout onto net.Conn: no deadline means forever
+func deadline(d time.Duration) time.Time {
+	if d < 0 {
+		return time.Time{}
+	}
+	if d == NonBlockingTimeout {
+		d = NetPollTime
+	}
+	return time.Now().Add(d)
+}
+
+func (self *netPort) Read(data []byte) (n int, e error) {
+	self.conn.SetReadDeadline(deadline(self.read_timeout))
+	n, e = self.conn.Read(data)
+	if self.read_timeout == NonBlockingTimeout && os.IsTimeout(e) {
+		return n, nil
+	}
+	return n, netError(e)
+}
+
+func (self *netPort) Write(data []byte) (n int, e error) {
+	self.conn.SetWriteDeadline(deadline(self.write_timeout))
+	n, e = self.conn.Write(data)
+	if self.write_timeout == NonBlockingTimeout && os.IsTimeout(e) {
+		return n, nil
+	}
+	return n, netError(e)
+}
+
+func (self *netPort) Close() error {
+	return self.conn.Close()
+}
+
+// RFC 2217, telnet com port control
+const (
+	TELNET_SE = 240
+	TELNET_SB = 250
+	TELNET_WILL = 251
+	TELNET_WONT = 252
+	TELNET_DO = 253
+	TELNET_DONT = 254
+	TELNET_IAC = 255
+
+	TELNET_OPT_BINARY = 0
+	TELNET_OPT_SGA = 3
+	TELNET_OPT_COM_PORT = 44
+
+	RFC2217_SET_BAUDRATE = 1
+	RFC2217_SET_DATASIZE = 2
+	RFC2217_SET_PARITY = 3
+	RFC2217_SET_STOPSIZE = 4
+	RFC2217_SET_CONTROL = 5
+
+	RFC2217_CONTROL_NO_FLOW = 1
+	RFC2217_CONTROL_XONXOFF = 2
+	RFC2217_CONTROL_RTSCTS = 3
+)
+
+var Rfc2217Parity = map[Parity]byte{
+	PARITY_NONE: 1,
+	PARITY_ODD: 2,
+	PARITY_EVEN: 3,
+	PARITY_MARK: 4,
+	PARITY_SPACE: 5,
+}
+
+// rfc2217Port is "rfc2217://host:port". Settings are sent once on open,
+// telnet negotiation on the way in is answered and stripped.
+type rfc2217Port struct {
+	*netPort
+	lock sync.Mutex // for writes: data and negotiation replies interleave
+	pending []byte // the rest of a command or escape a timeout has cut
+	state int
+	option byte
+}
+
+const (
+	telnetData = iota
+	telnetIAC
+	telnetOption
+	telnetSB
+	telnetSBIAC
+)
+
+func openRfc2217URL(u *url.URL, cfg PortConfig) (SerialPort, error) {
+	p, e := dialPort(u, cfg)
+	if e != nil {
+		return nil, e
+	}
+	port := &rfc2217Port{netPort: p}
+	e = port.negotiate(&cfg)
+	if e != nil {
+		p.Close()
+		return nil, e
+	}
+	return port, nil
+}
+
+func telnetEscape(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for _, b := range data {
+		out = append(out, b)
+		if b == TELNET_IAC {
+			out = append(out, TELNET_IAC)
+		}
+	}
+	return out
+}
+
+func rfc2217Command(command byte, value ...byte) []byte {
+	out := []byte{TELNET_IAC, TELNET_SB, TELNET_OPT_COM_PORT, command}
+	out = append(out, telnetEscape(value)...)
+	return append(out, TELNET_IAC, TELNET_SE)
+}
+
+func (self *rfc2217Port) negotiate(cfg *PortConfig) error {
+	speed := uint32(cfg.Speed)
+	control := byte(RFC2217_CONTROL_NO_FLOW)
+	if cfg.XonXoff {
+		control = RFC2217_CONTROL_XONXOFF
+	} else if cfg.RtsCts {
+		control = RFC2217_CONTROL_RTSCTS
+	}
+
+	var out []byte
+	for _, option := range []byte{TELNET_OPT_BINARY, TELNET_OPT_SGA, TELNET_OPT_COM_PORT} {
+		out = append(out, TELNET_IAC, TELNET_WILL, option)
+	}
+	for _, option := range []byte{TELNET_OPT_BINARY, TELNET_OPT_SGA} {
+		out = append(out, TELNET_IAC, TELNET_DO, option)
+	}
+	out = append(out, rfc2217Command(RFC2217_SET_BAUDRATE,
+		byte(speed >> 24), byte(speed >> 16), byte(speed >> 8), byte(speed))...)
+	out = append(out, rfc2217Command(RFC2217_SET_DATASIZE, byte(cfg.CharSize))...)
+	out = append(out, rfc2217Command(RFC2217_SET_PARITY, Rfc2217Parity[cfg.Parity])...)
+	out = append(out, rfc2217Command(RFC2217_SET_STOPSIZE, byte(cfg.StopBits))...)
+	out = append(out, rfc2217Command(RFC2217_SET_CONTROL, control)...)
+	return self.send(out)
+}
+
+// send queues a command whole: it goes out before any more data
+func (self *rfc2217Port) send(raw []byte) error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	self.pending = append(self.pending, raw...)
+	return self.flush()
+}
+
+// flush sends what it can of the pending bytes
+func (self *rfc2217Port) flush() error {
+	if len(self.pending) == 0 {
+		return nil
+	}
+	n, e := self.netPort.Write(self.pending)
+	self.pending = self.pending[n:]
+	return e
+}
+
+// telnetSent tells how many bytes of data the first sent bytes of its
+// escaped form carry; cut is what is missing of an IAC IAC sent in half
+func telnetSent(data []byte, sent int) (n, cut int) {
+	pos := 0
+	for i, b := range data {
+		size := 1
+		if b == TELNET_IAC {
+			size = 2
+		}
+		if pos + size > sent {
+			if pos < sent {
+				return i + 1, pos + size - sent
+			}
+			return i, 0
+		}
+		pos += size
+	}
+	return len(data), 0
+}
+
+func (self *rfc2217Port) String() string {
+	return fmt.Sprintf("<sio.Rfc2217(%+q)>", self.name)
+}
+
+// answer refuses everything we did not ask for
+func (self *rfc2217Port) answer(verb, option byte) {
+	switch option {
+	case TELNET_OPT_BINARY, TELNET_OPT_SGA, TELNET_OPT_COM_PORT:
+		return
+	}
+	switch verb {
+	case TELNET_DO:
+		self.send([]byte{TELNET_IAC, TELNET_WONT, option})
+	case TELNET_WILL:
+		self.send([]byte{TELNET_IAC, TELNET_DONT, option})
+	}
+}
+
+// filter strips telnet commands from raw in place; the parser state
+// survives across reads
+func (self *rfc2217Port) filter(raw []byte) []byte {
+	data := raw[:0]
+	for _, b := range raw {
+		switch self.state {
+		case telnetData:
+			if b == TELNET_IAC {
+				self.state = telnetIAC
+			} else {
+				data = append(data, b)
+			}
+		case telnetIAC:
+			switch b {
+			case TELNET_IAC:
+				data = append(data, b)
+				self.state = telnetData
+			case TELNET_WILL, TELNET_WONT, TELNET_DO, TELNET_DONT:
+				self.option = b
+				self.state = telnetOption
+			case TELNET_SB:
+				self.state = telnetSB
+			default:
+				self.state = telnetData
+			}
+		case telnetOption:
+			self.answer(self.option, b)
+			self.state = telnetData
+		case telnetSB: // server notifications are ignored for now
+			if b == TELNET_IAC {
+				self.state = telnetSBIAC
+			}
+		case telnetSBIAC:
+			if b == TELNET_SE {
+				self.state = telnetData
+			} else {
+				self.state = telnetSB
+			}
+		}
+	}
+	return data
+}
+
+// Read waits past telnet commands unless it is non-blocking: then nothing
+// or only commands is (0, nil)
+func (self *rfc2217Port) Read(data []byte) (n int, e error) {
+	for {
+		n, e = self.netPort.Read(data)
+		n = len(self.filter(data[:n]))
+		if n > 0 || e != nil || self.read_timeout == NonBlockingTimeout {
+			return n, e
+		}
+	}
+}
+
+// Write counts the bytes of data that went out. A timeout may cut an
+// escaped IAC in half, then the rest of it goes first next time.
+func (self *rfc2217Port) Write(data []byte) (n int, e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	e = self.flush()
+	if e != nil || len(self.pending) > 0 {
+		return 0, e
+	}
+	raw := telnetEscape(data)
+	sent, e := self.netPort.Write(raw)
+	n, cut := telnetSent(data, sent)
+	self.pending = append(self.pending, raw[sent:sent + cut]...)
+	return n, e
+}
+
+/* EOF */
@@ -0,0 +1,131 @@
+package sio
+
+// With INPCK and PARMRK the tty layer marks bad input in-band:
+//	\377 \377	- a good \377
+//	\377 \0 \0	- a BREAK (or a framing error on \0)
+//	\377 \0 X	- X came with a parity or framing error
+// The read path takes the marks out and reports them via LineErrors().
+
+type LineErrorKind uint
+const (
+	LINE_ERROR = LineErrorKind(0) // parity or framing, can't tell which
+	LINE_PARITY = LineErrorKind(1)
+	LINE_FRAMING = LineErrorKind(2)
+	LINE_BREAK = LineErrorKind(3)
+)
+
+func (self LineErrorKind) String() string {
+	switch self {
+	case LINE_PARITY: return "parity error"
+	case LINE_FRAMING: return "framing error"
+	case LINE_BREAK: return "BREAK"
+	}
+	return "parity or framing error"
+}
+
+// LineError is a bad byte or a BREAK. The byte is not in the data; Offset
+// is where it would have been, counting all the good bytes read so far.
+type LineError struct {
+	Offset int64
+	Kind LineErrorKind
+	Byte byte
+}
+
+// LineErrorQueue is how many LineError's are kept for the reader,
+// the rest are dropped
+const LineErrorQueue = 64
+
+const (
+	parmrkData = iota
+	parmrkFF
+	parmrkFF00
+)
+
+// parmrkDecoder keeps its state across reads: a mark may be split
+type parmrkDecoder struct {
+	state int
+	offset int64
+	icount serial_icounter_struct // as of the last error
+}
+
+// decode strips the marks from buf in place and returns the clean length
+func (self *parmrkDecoder) decode(buf []byte, report func(LineError)) int {
+	n := 0
+	for _, b := range buf {
+		switch self.state {
+		case parmrkData:
+			if b == 0xff {
+				self.state = parmrkFF
+				continue
+			}
+		case parmrkFF:
+			if b == 0x00 {
+				self.state = parmrkFF00
+				continue
+			}
+			self.state = parmrkData
+			if b != 0xff { // not a mark after all
+				buf[n] = 0xff
+				n++
+				self.offset++
+			}
+		case parmrkFF00:
+			self.state = parmrkData
+			kind := LINE_ERROR
+			if b == 0x00 {
+				kind = LINE_BREAK
+			}
+			report(LineError{Offset: self.offset, Kind: kind, Byte: b})
+			continue
+		}
+		buf[n] = b
+		n++
+		self.offset++
+	}
+	return n
+}
+
+// unmark decodes what read_some() got. The driver's counters tell parity
+// from framing errors when only one of them has moved.
+func (self *Port) unmark(buf []byte) int {
+	var found []LineError
+	n := self.parmrk.decode(buf, func(le LineError) {
+		found = append(found, le)
+	})
+	if len(found) == 0 {
+		return n
+	}
+
+	now, e := self.fd.TIOCGICOUNT()
+	if e == nil {
+		frame := now.frame - self.parmrk.icount.frame
+		parity := now.parity - self.parmrk.icount.parity
+		brk := now.brk - self.parmrk.icount.brk
+		self.parmrk.icount = now
+		for i := range found {
+			switch {
+			case found[i].Kind == LINE_BREAK && brk == 0 && frame > 0:
+				found[i].Kind = LINE_FRAMING
+			case found[i].Kind == LINE_ERROR && parity > 0 && frame == 0:
+				found[i].Kind = LINE_PARITY
+			case found[i].Kind == LINE_ERROR && frame > 0 && parity == 0:
+				found[i].Kind = LINE_FRAMING
+			}
+		}
+	}
+	for _, le := range found {
+		select {
+		case self.line_errors <- le:
+		default: // nobody listens
+		}
+	}
+	return n
+}
+
+// LineErrors delivers parity/framing errors and BREAKs seen by the read
+// path when PortConfig.MarkErrors is on. Closed by Close().
+func (self *Port) LineErrors() <-chan LineError {
+	return self.line_errors
+}
+
+/* EOF */
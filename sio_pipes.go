@@ -32,11 +32,21 @@ func (pipe *servicePipe) Fetch() (e error) {
 	if e != nil { return e; }
 	return nil
 }
+// FetchOne takes one notification, Fetch() takes all there are
+func (pipe *servicePipe) FetchOne() (e error) {
+	tmp := make([]byte, 1)
+	_, e = syscall.Read(int(pipe.rfd), tmp)
+	if e != nil { return e; }
+	return nil
+}
 func (pipe *servicePipe) Notify() (e error) {
 	_, e = syscall.Write(int(pipe.wfd), []byte("x"))
 	if e != nil { return e; }
 	return nil
 }
+func (pipe *servicePipe) pollRead() pollFd {
+	return pipe.rfd.pollFd(POLLIN)
+}
 func (pipe *servicePipe) FdSetRead(set *syscall.FdSet) {
 	pipe.rfd.FdSet(set)
 }
@@ -0,0 +1,70 @@
+// Support for poll(2) via ppoll, which every arch has: unlike select(2)
+// it does not care how big the fd numbers are
+package sio
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	POLLIN = 0x0001
+	POLLOUT = 0x0004
+	POLLERR = 0x0008
+	POLLHUP = 0x0010
+	POLLNVAL = 0x0020
+)
+
+// pollFd is struct pollfd
+type pollFd struct {
+	fd int32
+	events int16
+	revents int16
+}
+
+func (fd *Ioctl) pollFd(events int16) pollFd {
+	return pollFd{fd: int32(*fd), events: events}
+}
+
+// ready is true for errors and hangups as well: the next read or write
+// is what tells what has happened
+func (self *pollFd) ready() bool {
+	return self.revents != 0
+}
+
+// The wrapper, see select2()
+func poll2(fds []pollFd, seconds float64) (n int, e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	var tmo syscall.Timespec
+	var tmo_ptr *syscall.Timespec
+	if seconds != NoSelectTimeout {
+		if seconds < 0. {
+			seconds = 0.
+		}
+		tmo = syscall.NsecToTimespec(int64(seconds * 1e9))
+		tmo_ptr = &tmo
+	}
+	var fds_ptr *pollFd // none is a plain sleep
+	if len(fds) > 0 {
+		fds_ptr = &fds[0]
+	}
+	for {
+		r1, _, err := syscall.Syscall6(syscall.SYS_PPOLL,
+				uintptr(unsafe.Pointer(fds_ptr)), uintptr(len(fds)),
+				uintptr(unsafe.Pointer(tmo_ptr)), 0, 0, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != E_OK {
+			assert(err, "ppoll")
+		}
+		return int(r1), nil
+	}
+}
+
+/* EOF */
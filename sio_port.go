@@ -2,11 +2,9 @@ package sio
 
 import (
 	"os"
-	"io"
 	"time"
 	"strings"
 	"syscall"
-	"runtime"
 	"sync"
 	"fmt"
 )
@@ -29,19 +27,34 @@ type Port struct {
 	stat os.FileInfo
 	fd Ioctl
 	exclusive bool
-	inter_byte_timeout float64
-	speed BitRate
+	inter_byte_timeout time.Duration
+	speed, ispeed BitRate
 	char_size CharSize
 	parity Parity
 	stop_bits StopBits
 	xonxoff, rtscts, dsrdtr bool
+	dsrdtr_options DsrDtrOptions
+	dsrdtr_stop chan bool // see start_dsrdtr
+	hupcl bool
+	mark_errors bool
+	parmrk parmrkDecoder
+	line_errors chan LineError
+	dtr, rts LineLevel // on open
 	rs485 Rs485
-	termios syscall.Termios
+	rs485_soft bool // direction is switched by write(), see useRs485
+	echo []byte // our own bytes yet to come back, see strip_echo
+	read_timeout, write_timeout time.Duration
+	termios syscall.Termios // as it was before Open()
+	saved savedSettings
+	keep_settings bool
+	closed chan bool // closed by Close()
 	pipe struct {
 		abort_read, abort_write servicePipe
 	}
 	sysfs []string
+	by_id, by_path []string // see StableNames
 	lock sync.Mutex
+	rlock, wlock sync.Mutex // one reader and one writer at a time
 }
 
 // NewSerialPort("/dev/ttyUSB0") returns ref to an open Port instance
@@ -59,9 +72,14 @@ func (self *Port) SysFS() []string {
 func (self *Port) String() string {
 	if self.IsOpen() {
 		major, minor := self.DeviceId()
-		return fmt.Sprintf("<sio.Port(%+q):%s [%d:%d]>",
-					self.file.Name(),
-					self.DeviceClassName(), major, minor)
+		name := fmt.Sprintf("%+q", self.file.Name())
+		if stable := stableName(self.by_id, self.by_path); stable != "" {
+			name += fmt.Sprintf(" aka %+q", stable)
+		}
+		return fmt.Sprintf("<sio.Port(%s):%s [%d:%d] %s>",
+					name,
+					self.DeviceClassName(), major, minor,
+					self.Mode())
 	} else {
 		return "<sio.Port>"
 	}
@@ -70,14 +88,25 @@ func (self *Port) IsOpen() bool {
 	return self.file != nil && self.file.Name() != ""
 }
 func (self *Port) Open(path string) (e error) {
+	return self.OpenWithConfig(path, DefaultPortConfig())
+}
+func (self *Port) OpenWithConfig(path string, cfg PortConfig) (e error) {
+	var opened, saved bool
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
+			if opened {
+				self.abandon(saved)
+			}
 		}
 	}()
 
 	self.lock.Lock(); defer self.lock.Unlock()
 
+	// open the kernel's name, by-id & co are kept apart
+	if device, e := ResolvePath(path); e == nil {
+		path = device
+	}
 	stat, e := os.Stat(path)
 	if e != nil {
 		if os.IsNotExist(e) {
@@ -88,53 +117,87 @@ func (self *Port) Open(path string) (e error) {
 	assertb(isCharDevice(stat), "Port.Open(%+q): not a character device", path)
 	assertb(!self.IsOpen(), "Port.Open(%+q): *object* is already open", path)
 
-	self.stat = stat
-
-	self.exclusive = true
-	self.speed = BIT_RATE_B9600
+	e = cfg.Validate()
+	assert(e, "Port.Open(%+q): %w", path, e)
 
-	// "8N1"
-	self.char_size, self.parity, self.stop_bits = CHAR_SIZE_8, PARITY_NONE, STOP_BITS_1
-
-	self.xonxoff = false
-	self.rtscts = false
-	self.dsrdtr = false
-	self.rs485.Enabled = false
+	self.stat = stat
+	self.useConfig(&cfg)
 
 	self.file, e = os.OpenFile(path, PortOpenFlags, 0)
 	assert(e, "Port.Open(%+q)@OpenFile: %w", path, e)
+	opened = true
 
 	self.fd.Set(self.file.Fd())
+	assert(self.fd.NonBlock(true), "NonBlock") // File.Fd() made it blocking
 
 	major, minor := self.DeviceId()
 	var sysfs SysFS
 	sysfs.Use(GetRDev(self.stat))
 	self.sysfs = sysfs.Locate(SysfsClass, major, minor)
+	self.by_id, self.by_path = StableNames(path)
 
+	e = self.save()
+	assert(e, "Open: cannot save settings")
+	saved = true
 	e = self.fd.Reconfigure(&self.termios, self)
 	assert(e, "Open: cannot configure port")
 
-	if !self.dsrdtr {
-		self.fd.SetDTR(false) // doesn't work actually
-		// assert(self.fd.SetDTR(false), "SetDTR")
-	}
-	if !self.rtscts {
-		self.fd.SetRTS(false) // doesn't work actually
-		// assert(self.fd.SetRTS(false), "SetRTS")
-	}
+	// the driver has raised both on open already, nothing to do about it
+	e = self.setLines(self.dtr, self.rts)
+	assert(e, "Open: cannot set DTR/RTS: %w", e)
 	assert(self.ResetInput(), "ResetInput")
 	assert(self.ResetOutput(), "ResetOutput")
 	assert(self.pipe.abort_read.Open(), "pipe(read)")
 	assert(self.pipe.abort_write.Open(), "pipe(write)")
+	self.closed = make(chan bool)
+	self.parmrk = parmrkDecoder{}
+	self.parmrk.icount, _ = self.fd.TIOCGICOUNT()
+	self.line_errors = make(chan LineError, LineErrorQueue)
+	if self.dsrdtr {
+		e = self.start_dsrdtr()
+		assert(e, "Open: DSR/DTR handshake")
+	}
 
 	return nil
 }
-func (self *Port) Close() error {
+// abandon undoes what a failed open has done so far
+func (self *Port) abandon(saved bool) {
+	if saved {
+		self.restore()
+	}
+	self.file.Close()
+	self.file = nil
+	self.fd = ZeroIoctl
+	for _, pipe := range []*servicePipe{
+		&self.pipe.abort_read,
+		&self.pipe.abort_write,
+	} {
+		if pipe.r != nil {
+			pipe.Close()
+		}
+	}
+	self.by_id, self.by_path = nil, nil
+}
+
+// Close restores the original device settings unless told otherwise,
+// see PortConfig.KeepSettings
+func (self *Port) Close() (e error) {
+	self.CancelRead() // wake up whoever is blocked in I/O
+	self.CancelWrite()
+	self.rlock.Lock(); defer self.rlock.Unlock()
+	self.wlock.Lock(); defer self.wlock.Unlock()
 	self.lock.Lock(); defer self.lock.Unlock()
 
 	if self.file != nil {
+		self.stop_dsrdtr()
+		if !self.keep_settings {
+			e = self.restore()
+		}
 		self.file.Close()
 		self.file = nil
+		self.by_id, self.by_path = nil, nil
+		close(self.closed)
+		close(self.line_errors)
 		self.fd = ZeroIoctl
 		for _, pipe := range []servicePipe{
 			self.pipe.abort_read,
@@ -143,7 +206,7 @@ func (self *Port) Close() error {
 			pipe.Close()
 		}
 	}
-	return nil
+	return e
 }
 
 func (self *Port) DeviceId() (major, minor uint64) {
@@ -173,103 +236,149 @@ func (self *Port) cancel_write() (e error) {
 	return nil
 }
 
-func (self *Port) write(data []byte) (sent int, e error) {
+// CancelRead wakes up a read blocked in another goroutine; that one returns
+// PortCancelledError. If there is no read in progress the next one does.
+func (self *Port) CancelRead() error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	return self.cancel_read()
+}
+
+// CancelWrite is CancelRead() for writes.
+func (self *Port) CancelWrite() error {
 	self.lock.Lock(); defer self.lock.Unlock()
 
+	return self.cancel_write()
+}
+
+func ignorable(e error) bool {
+	switch e {
+	case syscall.EAGAIN, syscall.EINTR:
+		return true
+	}
+	return false
+}
+
+// write sends data until done, the timeout or cancel_write()
+// write switches the RS485 direction and goes through the DSR/DTR
+// handshake if those are on
+func (self *Port) write(data []byte, tmo *timeout) (sent int, e error) {
+	if !self.IsOpen() { return -1, PortNotOpenError; }
+	if self.rs485_soft {
+		return self.write_rs485(data, tmo)
+	}
+	return self.write_flow(data, tmo)
+}
+
+func (self *Port) write_flow(data []byte, tmo *timeout) (sent int, e error) {
+	if self.dsrdtr {
+		return self.write_dsrdtr(data, tmo)
+	}
+	return self.write_some(data, tmo)
+}
+
+func (self *Port) write_some(data []byte, tmo *timeout) (sent int, e error) {
 	if !self.IsOpen() { return -1, PortNotOpenError; }
 	data_len := len(data)
 	if data_len == 0 {
 		return 0, nil
 	}
-	var timeout time.Duration = DefaultTimeout
-	var tmo float64
 	var n int
-	var rset, wset syscall.FdSet
-	var suspect error
-	t0 := time.Now()
 	for sent < data_len {
-		runtime.Gosched()
-		
-		timeout -= time.Now().Sub(t0)
-
 		n, e = syscall.Write(int(self.fd), data[sent:])
-		assert(e, "write")
-		sent += n
-
-		/*
-		if timeout.is_non_blocking {
-			return sent, nil // that's just fine
-		}
-		*/
-		self.fd.FdSet(&wset)
-		self.pipe.abort_write.FdSetRead(&rset)
-		n = 0
-		/*
-		if timeout.is_infinite {
-			tmo = NoSelectTimeout
-			suspect = NewPortError("write failed on select")
-		} else {
-		*/
-			if timeout.Seconds() < 0. {
-				return sent, PortTimeoutError
-			}
-			tmo = timeout.Seconds()
-			suspect = PortTimeoutError
-		/*
-		}
-		*/
-		n, e = select2(&rset, &wset, nil, tmo)
-		assert(e, "select")
-		if n > 0 && self.pipe.abort_write.FdIsSetRead(&rset) {
+		if e != nil && !ignorable(e) {
+			assert(e, "write")
+		}
+		if n > 0 {
+			sent += n
+		}
+		if sent == data_len || tmo.isNonBlocking() {
+			break // non-blocking: that's just fine
+		}
+		if tmo.expired() {
+			return sent, PortTimeoutError
+		}
+
+		fds := []pollFd{
+			self.fd.pollFd(POLLOUT),
+			self.pipe.abort_write.pollRead(),
+		}
+		n, e = poll2(fds, tmo.seconds())
+		assert(e, "poll")
+		if fds[1].ready() {
 			assert(self.pipe.abort_write.Fetch(),
 				"read(pipe.abort_write.r)")
-			break
+			return sent, PortCancelledError
 		}
-		if n == 0 || !self.fd.FdIsSet(&wset) {
-			return sent, suspect
+		if n == 0 {
+			return sent, PortTimeoutError
 		}
 	}
 	return sent, nil
 }
 
-func (self *Port) read(max int) (data []byte, e error) {
-	self.lock.Lock(); defer self.lock.Unlock()
-
-	if !self.IsOpen() { return nil, PortNotOpenError; }
-	var timeout time.Duration = DefaultTimeout
-	var n int
-	t0 := time.Now()
-	buf := make([]byte, max)
-	for len(data) < max {
-		runtime.Gosched()
-		// TODO: ignore EAGAIN, EALREADY, EWOULDBLOCK, EINPROGRESS, EINTR
-		//	 all over the code within the loop
-
-		timeout -= time.Now().Sub(t0)
-
-		var rset syscall.FdSet
-		self.fd.FdSet(&rset)
-		self.pipe.abort_read.FdSetRead(&rset)
-
-		n, e = select2(&rset, nil, nil, timeout.Seconds())
-		assert(e, "select")
+// read_some waits for data until the timeout or cancel_read() and
+// returns as soon as there is any
+func (self *Port) read_some(buf []byte, tmo *timeout) (n int, e error) {
+	if !self.IsOpen() { return 0, PortNotOpenError; }
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	for {
+		fds := []pollFd{
+			self.fd.pollFd(POLLIN),
+			self.pipe.abort_read.pollRead(),
+		}
+		n, e = poll2(fds, tmo.seconds())
+		assert(e, "poll")
 
 		if n == 0 { // timeout
-			return data, PortTimeoutError
+			if tmo.isNonBlocking() {
+				return 0, nil
+			}
+			return 0, PortTimeoutError
 		}
 
-		if self.pipe.abort_read.FdIsSetRead(&rset) {
+		if fds[1].ready() {
 			e = self.pipe.abort_read.Fetch()
 			assert(e, "read(pipe.abort_read.r)")
-			break
+			return 0, PortCancelledError
 		}
 
-		n, e = syscall.Read(int(self.fd), buf[:max - len(data)])
+		n, e = syscall.Read(int(self.fd), buf)
+		if e != nil && ignorable(e) {
+			continue
+		}
 		assert(e, "read")
-		if n == 0 { // no data after false-positive select
-			return data, NewPortError("Device disconnected or multiple access")
+		if n == 0 { // no data after false-positive poll
+			return 0, NewPortError("Device disconnected or multiple access")
+		}
+		if self.mark_errors {
+			n = self.unmark(buf[:n])
+			if n == 0 && !tmo.isNonBlocking() {
+				continue // nothing but errors
+			}
 		}
+		if self.rs485_soft {
+			n = self.strip_echo(buf[:n])
+			if n == 0 && !tmo.isNonBlocking() {
+				continue // nothing but our own echo
+			}
+		}
+		return n, nil
+	}
+}
+
+// read is pyserial's read(size): wait for max bytes until the timeout
+func (self *Port) read(max int, tmo *timeout) (data []byte, e error) {
+	var n int
+	buf := make([]byte, max)
+	for len(data) < max {
+		n, e = self.read_some(buf[:max - len(data)], tmo)
 		data = append(data, buf[:n]...)
+		if e != nil || n == 0 {
+			return data, e
+		}
 	}
 	return data, nil
 }
@@ -281,84 +390,67 @@ func (self *Port) InWaiting() (n uint32, e error) {
 	return self.fd.TIOCINQ()
 }
 
+// Read waits up to ReadTimeout() for data and returns what is there
 func (self *Port) Read(data []byte) (n int, e error) {
-	self.lock.Lock(); defer self.lock.Unlock()
+	self.rlock.Lock(); defer self.rlock.Unlock()
 
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
-	// fmt.Println("Read")
-	self.SetDeadline(time.Now().Add(DefaultTimeout))
-	n, e = self.file.Read(data)
-	if e != io.EOF {
-		assert(e, "Read<%+q>(%d): %w", self.file.Name(), len(data), e)
-	}
-	return n, nil
+	n, e = self.read_some(data, newTimeout(self.ReadTimeout()))
+	return n, e
 }
+// Write sends all the data unless WriteTimeout() says otherwise
 func (self *Port) Write(data []byte) (n int, e error) {
-	self.lock.Lock(); defer self.lock.Unlock()
+	self.wlock.Lock(); defer self.wlock.Unlock()
 
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
-	// fmt.Println("Write", data)
-	self.SetDeadline(time.Now().Add(DefaultTimeout))
-	n, e = self.file.Write(data)
-	assert(e, "Write<%+q>(%d): %w", self.file.Name(), len(data), e)
-	return n, nil
+	n, e = self.write(data, newTimeout(self.WriteTimeout()))
+	return n, e
 }
-func (self *Port) SetDeadline(t time.Time) (e error) {
-	defer func() {
-		if state := recover(); state != nil {
-			e = WrapError(state.(error))
+func (self *Port) read_line(tmo *timeout) (s string, e error) {
+	var b = make([]byte, 1)
+	var n int
+	for b[0] != '\n' {
+		n, e = self.read_some(b, tmo)
+		if e != nil {
+			return s, e
 		}
-	}()
-	e = self.file.SetDeadline(t)
-	assert(e, "SetDeadline<%+q>(%+v): %w", self.file.Name(), t, e)
-	return nil
+		if n == 0 { // non-blocking
+			return s, nil
+		}
+		s += string(b[:1])
+	}
+	return s, nil
 }
+// ReadLine reads up to '\n'; ReadTimeout() is for the whole line
 func (self *Port) ReadLine() (s string, e error) {
+	self.rlock.Lock(); defer self.rlock.Unlock()
+
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
-	var b = make([]byte, 1)
-	var n int
-	for b[0] != '\n' {
-		runtime.Gosched()
-		n, e = self.Read(b)
-		assert(e, "ReadLine<%+q>: %w", self.file.Name(), e)
-		if n > 0 {
-			s += string(b[:1])
-		}
-	}
-	return s, nil
+	return self.read_line(newTimeout(self.ReadTimeout()))
 }
+// WriteLine sends s and '\r'; WriteTimeout() is for the whole line
 func (self *Port) WriteLine(s string) (e error) {
+	self.wlock.Lock(); defer self.wlock.Unlock()
+
 	defer func() {
 		if state := recover(); state != nil {
 			e = WrapError(state.(error))
 		}
 	}()
-	l := len(s)
-	b := make([]byte, l)
-	b = []byte(s)
-	var n int
-	for ; l > 0; {
-		runtime.Gosched()
-		n, e = self.Write(b)
-		assert(e, "WriteLine<%+q>(%+q): %w", self.file.Name(), string(b), e)
-		l -= n
-		b = b[n:]
-	}
-	n, e = self.file.Write([]byte("\r"))
-	assert(e, "WriteLine<%+q>(%+q): %w", self.file.Name(), "\r", e)
-	return nil
+	_, e = self.write([]byte(s + "\r"), newTimeout(self.WriteTimeout()))
+	return e
 }
 func (self *Port) hasEnd(s string, ends []string) bool {
 	for _, e := range ends {
@@ -368,22 +460,32 @@ func (self *Port) hasEnd(s string, ends []string) bool {
 	}
 	return false
 }
-func (self *Port) ReadUntil(ends []string) (s string, e error) {
-	defer func() {
-		if state := recover(); state != nil {
-			e = WrapError(state.(error))
-		}
-	}()
+func (self *Port) read_until(ends []string, tmo *timeout) (s string, e error) {
 	var x string
 	for {
-		x, e = self.ReadLine()
-		assert(e, "ReadUntil<%+q>(%+v): %w", self.file.Name(), ends, e)
+		x, e = self.read_line(tmo)
 		s += x
+		if e != nil {
+			return s, e
+		}
 		if self.hasEnd(s, ends) {
 			return s, nil
 		}
+		if !strings.HasSuffix(x, "\n") { // non-blocking
+			return s, nil
+		}
 	}
-	return s, nil
+}
+// ReadUntil reads lines until one of ends; ReadTimeout() is for all of it
+func (self *Port) ReadUntil(ends []string) (s string, e error) {
+	self.rlock.Lock(); defer self.rlock.Unlock()
+
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+	return self.read_until(ends, newTimeout(self.ReadTimeout()))
 }
 
 type CommandChannel chan []byte
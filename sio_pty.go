@@ -0,0 +1,89 @@
+package sio
+
+import (
+	"fmt"
+	"net/url"
+	"syscall"
+	"unsafe"
+)
+
+const PtyMaster = "/dev/ptmx"
+
+func (fd *Ioctl) TIOCSPTLCK(set bool) (e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	var data [1]int32
+	if set {
+		data[0] = 1
+	}
+	_, _, err := fd.ioctl(syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&data)))
+	assertb(err == E_OK, "ioctl(%v, TIOCSPTLCK, *): %v", fd, err)
+	return nil
+}
+
+func (fd *Ioctl) TIOCGPTN() (n uint32, e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	var data [1]uint32
+	_, _, err := fd.ioctl(syscall.TIOCGPTN, uintptr(unsafe.Pointer(&data)))
+	assertb(err == E_OK, "ioctl(%v, TIOCGPTN, *): %v", fd, err)
+	return data[0], nil
+}
+
+// PtyPort is "pty://": the master side of a new pseudo terminal.
+// Let the other program open Peer().
+type PtyPort struct {
+	*Port
+	peer string
+}
+
+func (self *PtyPort) Peer() string {
+	return self.peer
+}
+
+func (self *PtyPort) String() string {
+	return fmt.Sprintf("<sio.Pty(%+q)>", self.peer)
+}
+
+// OpenPty creates a pseudo terminal and returns its master side
+func OpenPty(cfg PortConfig) (pty *PtyPort, e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	cfg.Exclusive = false // all the masters are the same /dev/ptmx inode
+	p, e := OpenWithConfig(PtyMaster, cfg)
+	assert(e, "OpenPty: %w", e)
+
+	e = p.fd.TIOCSPTLCK(false)
+	if e != nil {
+		p.Close()
+		assert(e, "OpenPty: unlock")
+	}
+	n, e := p.fd.TIOCGPTN()
+	if e != nil {
+		p.Close()
+		assert(e, "OpenPty: ptn")
+	}
+	return &PtyPort{Port: p, peer: fmt.Sprintf("/dev/pts/%d", n)}, nil
+}
+
+func openPtyURL(u *url.URL, cfg PortConfig) (SerialPort, error) {
+	p, e := OpenPty(cfg)
+	if e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+/* EOF */
@@ -0,0 +1,93 @@
+package sio
+
+import (
+	"syscall"
+	"time"
+)
+
+// What the device looked like before Open(), to be put back on Close().
+// Port.termios holds the termios itself (see save).
+type savedSettings struct {
+	ispeed, ospeed BitRate // termios has no room for BOTHER speeds
+	rs485 serial_rs485
+	has_rs485 bool
+	low_latency bool
+	has_low_latency bool
+}
+
+// save remembers what can be remembered; drivers that know nothing
+// of RS485 or serial_struct are fine, but there must be a termios
+func (self *Port) save() (e error) {
+	self.termios, e = self.fd.TcGetAttr()
+	if e != nil { return e; }
+	self.saved.ispeed, self.saved.ospeed, _ = self.fd.TcGetSpeed()
+	self.saved.rs485, e = self.fd.TIOCGRS485()
+	self.saved.has_rs485 = e == nil
+	self.saved.low_latency, e = self.fd.LowLatency()
+	self.saved.has_low_latency = e == nil
+	return nil
+}
+
+// CloseDrain is how long Close() lets the output queue go out when the
+// driver's closing_wait does not say; what flow control holds back
+// longer is thrown away
+const CloseDrain = 2 * time.Second
+
+func (self *Port) closing_wait() time.Duration {
+	ss, e := self.fd.TIOCGSERIAL()
+	if e != nil || ss.closing_wait == ASYNC_CLOSING_WAIT_INF {
+		return CloseDrain
+	}
+	if ss.closing_wait == ASYNC_CLOSING_WAIT_NONE {
+		return NonBlockingTimeout
+	}
+	return time.Duration(ss.closing_wait) * 10 * time.Millisecond
+}
+
+// restore puts the original settings back and returns the first failure.
+// What is still in the output queue goes out first, at our settings,
+// for as long as closing_wait() says.
+func (self *Port) restore() (e error) {
+	var keep = func(err error) {
+		if e == nil && err != nil {
+			e = err
+		}
+	}
+
+	termios := self.termios
+	if !self.hupcl { // or the close right after would drop DTR anyway
+		termios.Cflag &= ^HUPCL
+	}
+	switch err := self.wait_sent(newTimeout(self.closing_wait()), nil); err {
+	case PortTimeoutError:
+		keep(self.fd.TcFlush(syscall.TCOFLUSH))
+	default:
+		keep(err)
+	}
+	keep(self.fd.TcSetAttr(termios))
+	if self.termios.Cflag & CBAUD == BOTHER {
+		keep(self.fd.TcSetSpeed(self.saved.ispeed, self.saved.ospeed))
+	}
+	if self.saved.has_rs485 {
+		current, err := self.fd.TIOCGRS485()
+		if err == nil && current != self.saved.rs485 {
+			keep(self.fd.TIOCSRS485(&self.saved.rs485))
+		}
+	}
+	if self.saved.has_low_latency {
+		current, err := self.fd.LowLatency()
+		if err == nil && current != self.saved.low_latency {
+			keep(self.fd.SetLowLatency(self.saved.low_latency))
+		}
+	}
+	return e
+}
+
+// SetKeepSettings(true) makes Close() leave the device as configured
+func (self *Port) SetKeepSettings(keep bool) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	self.keep_settings = keep
+}
+
+/* EOF */
@@ -1,47 +1,106 @@
 package sio
 
+import "time"
+
+// serial_rs485 flags, see linux/serial.h
 const (
     SER_RS485_ENABLED = 0x01
     SER_RS485_RTS_ON_SEND = 0x02
     SER_RS485_RTS_AFTER_SEND = 0x04
     SER_RS485_RX_DURING_TX = 0x10
+    SER_RS485_TERMINATE_BUS = 0x20
+    SER_RS485_ADDRB = 0x40
+    SER_RS485_ADDR_RECV = 0x80
+    SER_RS485_ADDR_DEST = 0x100
 )
 
+// struct serial_rs485, see linux/serial.h
+type serial_rs485 struct {
+	flags uint32
+	delay_rts_before_send uint32 // ms
+	delay_rts_after_send uint32 // ms
+	addr_recv uint8
+	addr_dest uint8
+	padding0 [2]uint8
+	padding1 [4]uint32
+}
+
+// Rs485 is the driver's RS485 mode. The delays go to the kernel in whole
+// milliseconds, rounded up; zero means no delay.
 type Rs485 struct {
 	Enabled bool
-	Loopback bool
+	Loopback bool // receive our own transmission
 	Rts_level_for_tx, Rts_level_for_rx bool
-	Delay_before_tx, Delay_before_rx bool
-	Delay_before_tx_value, Delay_before_rx_value float64
+	Delay_before_tx, Delay_before_rx time.Duration
+	Terminate_bus bool // switch the bus termination on, if the board can
+	Addressing bool // 9th bit addressing (ADDRB)
+	Addr_recv, Addr_dest bool // filter on / send to the address below
+	Addr_recv_value, Addr_dest_value uint8
+	// user space direction control, see useRs485
+	Software Rs485Software
+	Use_dtr bool // switch DTR instead of RTS
+	Discard_echo bool // drop our own bytes read back unless Loopback
+}
+
+func rs485Millis(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	return uint32((d + time.Millisecond - 1) / time.Millisecond)
 }
 
-func (self *Rs485) Update(buf *[8]uint32) {
-	if self.Enabled {
-		buf[0] |= SER_RS485_ENABLED
-		if self.Loopback {
-			buf[0] |= SER_RS485_RX_DURING_TX
-		} else {
-			buf[0] &= ^uint32(SER_RS485_RX_DURING_TX)
-		}
-		if self.Rts_level_for_tx {
-			buf[0] |= SER_RS485_RTS_ON_SEND
-		} else {
-			buf[0] &= ^uint32(SER_RS485_RTS_ON_SEND)
-		}
-		if self.Rts_level_for_rx {
-			buf[0] |= SER_RS485_RTS_AFTER_SEND
-		} else {
-			buf[0] &= ^uint32(SER_RS485_RTS_AFTER_SEND)
-		}
-		if self.Delay_before_tx {
-			buf[1] = uint32(self.Delay_before_tx_value * 1000.)
-		}
-		if self.Delay_before_rx {
-			buf[2] = uint32(self.Delay_before_rx_value * 1000.)
-		}
+func rs485Flag(flags *uint32, flag uint32, set bool) {
+	if set {
+		*flags |= flag
 	} else {
-		buf[0] = 0
+		*flags &= ^flag
 	}
 }
 
+// update puts self over what the driver has; disabled means all flags off
+func (self *Rs485) update(rs *serial_rs485) {
+	if !self.Enabled {
+		rs.flags = 0
+		return
+	}
+	rs.flags |= SER_RS485_ENABLED
+	rs485Flag(&rs.flags, SER_RS485_RX_DURING_TX, self.Loopback)
+	rs485Flag(&rs.flags, SER_RS485_RTS_ON_SEND, self.Rts_level_for_tx)
+	rs485Flag(&rs.flags, SER_RS485_RTS_AFTER_SEND, self.Rts_level_for_rx)
+	rs485Flag(&rs.flags, SER_RS485_TERMINATE_BUS, self.Terminate_bus)
+	rs485Flag(&rs.flags, SER_RS485_ADDRB, self.Addressing)
+	rs485Flag(&rs.flags, SER_RS485_ADDR_RECV, self.Addr_recv)
+	rs485Flag(&rs.flags, SER_RS485_ADDR_DEST, self.Addr_dest)
+	rs.delay_rts_before_send = rs485Millis(self.Delay_before_tx)
+	rs.delay_rts_after_send = rs485Millis(self.Delay_before_rx)
+	rs.addr_recv = self.Addr_recv_value
+	rs.addr_dest = self.Addr_dest_value
+}
+
+func newRs485(rs serial_rs485) Rs485 {
+	return Rs485{
+		Enabled: rs.flags & SER_RS485_ENABLED != 0,
+		Loopback: rs.flags & SER_RS485_RX_DURING_TX != 0,
+		Rts_level_for_tx: rs.flags & SER_RS485_RTS_ON_SEND != 0,
+		Rts_level_for_rx: rs.flags & SER_RS485_RTS_AFTER_SEND != 0,
+		Delay_before_tx: time.Duration(rs.delay_rts_before_send) * time.Millisecond,
+		Delay_before_rx: time.Duration(rs.delay_rts_after_send) * time.Millisecond,
+		Terminate_bus: rs.flags & SER_RS485_TERMINATE_BUS != 0,
+		Addressing: rs.flags & SER_RS485_ADDRB != 0,
+		Addr_recv: rs.flags & SER_RS485_ADDR_RECV != 0,
+		Addr_dest: rs.flags & SER_RS485_ADDR_DEST != 0,
+		Addr_recv_value: rs.addr_recv,
+		Addr_dest_value: rs.addr_dest,
+	}
+}
+
+// GetRs485 reports what the driver has actually applied, which may
+// differ from Config().Rs485: drivers drop what they cannot do.
+func (self *Port) GetRs485() (rs485 Rs485, e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return rs485, PortNotOpenError; }
+	return self.fd.GetRs485()
+}
+
 /* EOF */
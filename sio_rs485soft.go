@@ -0,0 +1,113 @@
+package sio
+
+import "time"
+
+// Rs485Software is when write() switches the RS485 direction itself:
+// it raises RTS (or DTR) to the Rts_level_for_tx level, waits
+// Delay_before_tx, writes, drains, waits Delay_before_rx and puts the
+// line back to Rts_level_for_rx. Timing is only as good as tcdrain(3)
+// and the scheduler; a driver that does it is always better.
+type Rs485Software uint
+const (
+	RS485_SOFT_NEVER = Rs485Software(0) // the driver does it or Open fails
+	RS485_SOFT_FALLBACK = Rs485Software(1) // if the driver rejects TIOCSRS485
+	RS485_SOFT_ALWAYS = Rs485Software(2)
+)
+
+// useRs485 sets the driver up or falls back to user space, as configured;
+// then the line is left at the receive level
+func (fd *Ioctl) useRs485(rs485 Rs485) (soft bool, e error) {
+	if rs485.Software != RS485_SOFT_ALWAYS {
+		e = fd.SetRs485(rs485)
+		if e == nil || rs485.Software == RS485_SOFT_NEVER {
+			return false, e
+		}
+	}
+	e = fd.setRs485Line(rs485, rs485.Rts_level_for_rx)
+	if e != nil { return false, e; }
+	return true, nil
+}
+
+func (fd *Ioctl) setRs485Line(rs485 Rs485, set bool) error {
+	if rs485.Use_dtr {
+		return fd.SetDTR(set)
+	}
+	return fd.SetRTS(set)
+}
+
+// write_rs485 keeps the line at the transmit level for the whole write,
+// whatever way it ends
+func (self *Port) write_rs485(data []byte, tmo *timeout) (sent int, e error) {
+	rs485 := self.rs485
+	discard := rs485.Discard_echo && !rs485.Loopback
+	e = self.fd.setRs485Line(rs485, rs485.Rts_level_for_tx)
+	assert(e, "RS485 %s: %w", rs485Line(rs485), e)
+	if discard {
+		self.expect_echo(data)
+	}
+	if rs485.Delay_before_tx > 0 {
+		time.Sleep(rs485.Delay_before_tx)
+	}
+	defer func() {
+		drained := self.fd.TcDrain()
+		if rs485.Delay_before_rx > 0 {
+			time.Sleep(rs485.Delay_before_rx)
+		}
+		back := self.fd.setRs485Line(rs485, rs485.Rts_level_for_rx)
+		if discard {
+			self.unexpect_echo(len(data) - sent)
+		}
+		if e == nil && drained != nil {
+			e = drained
+		}
+		if e == nil && back != nil {
+			e = back
+		}
+	}()
+	return self.write_flow(data, tmo)
+}
+
+func rs485Line(rs485 Rs485) string {
+	if rs485.Use_dtr {
+		return "DTR"
+	}
+	return "RTS"
+}
+
+// expect_echo and unexpect_echo tell strip_echo what to look for:
+// all of data before the write, less what has not gone out after it
+func (self *Port) expect_echo(data []byte) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	self.echo = append(self.echo, data...)
+}
+func (self *Port) unexpect_echo(unsent int) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if unsent >= len(self.echo) {
+		self.echo = nil
+	} else {
+		self.echo = self.echo[:len(self.echo) - unsent]
+	}
+}
+
+// strip_echo drops our own bytes from the head of buf; the first byte
+// that does not match means the rest of the echo is lost
+func (self *Port) strip_echo(buf []byte) int {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if len(self.echo) == 0 {
+		return len(buf)
+	}
+	i := 0
+	for i < len(buf) && len(self.echo) > 0 && buf[i] == self.echo[0] {
+		self.echo = self.echo[1:]
+		i++
+	}
+	if i < len(buf) {
+		self.echo = nil
+	}
+	return copy(buf, buf[i:])
+}
+
+/* EOF */
@@ -15,7 +15,9 @@ func _fd_bit(fd_orig uintptr) (clause uint64, bit int64) {
 	const bits = 64
 	var fd = uint64(fd_orig)
 	clause = fd / bits
-	if clause > 15 { panic("fd too big"); }
+	if clause > 15 {
+		panic(NewPortError("fd %d is too big for select(2), see poll2()", fd))
+	}
 	bit = 1 << (fd % bits)
 	return clause, bit
 }
@@ -55,7 +57,29 @@ func fd_count(set *syscall.FdSet) (n int) {
 	return n
 }
 
+// fd_nfds is the highest fd in the set plus one
+func fd_nfds(set *syscall.FdSet) (n int) {
+	var i uintptr
+	if set == nil {
+		return 0
+	}
+	for i = 0; i < 64 * 16; i++ {
+		if fd_isset(i, set) { n = int(i) + 1; }
+	}
+	return n
+}
+
+func max_nfds(sets ...*syscall.FdSet) (n int) {
+	for _, set := range sets {
+		if m := fd_nfds(set); m > n { n = m; }
+	}
+	return n
+}
+
 func timeval(seconds float64) (res syscall.Timeval) {
+	if seconds < 0. {
+		seconds = 0.
+	}
 	res.Sec = int64(seconds)
 	res.Usec = int64(seconds * 1000000.) % 1000000
 	return res
@@ -77,8 +101,12 @@ func select2(r, w, x *syscall.FdSet, seconds float64) (n int, e error) {
 		tmo = timeval(seconds)
 		tmo_ptr = &tmo
 	}
-	n, e = syscall.Select(fd_count(r) + fd_count(w) + fd_count(x),
-				r, w, x, tmo_ptr)
+	for {
+		n, e = syscall.Select(max_nfds(r, w, x), r, w, x, tmo_ptr)
+		if e != syscall.EINTR {
+			break
+		}
+	}
 	assert(e, "select")
 	return n, nil
 }
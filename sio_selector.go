@@ -0,0 +1,70 @@
+package sio
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Selector picks one port out of ListPorts() by what is stable across
+// reboots and replugs; zero fields match anything
+type Selector struct {
+	VID, PID uint16
+	Serial string // iSerial
+	Location string // like "1-1.2", see PortInfo
+	Pattern string // regexp over the Device, Description and HWID()
+}
+
+// Filter returns the ports that match
+func (self Selector) Filter(ports []PortInfo) (found []PortInfo, e error) {
+	var re *regexp.Regexp
+	if self.Pattern != "" {
+		re, e = regexp.Compile(self.Pattern)
+		if e != nil { return nil, e; }
+	}
+	for _, info := range ports {
+		if self.VID != 0 && (!info.Usb || info.VID != self.VID) { continue; }
+		if self.PID != 0 && (!info.Usb || info.PID != self.PID) { continue; }
+		if self.Serial != "" && info.Serial_number != self.Serial { continue; }
+		if self.Location != "" && info.Location != self.Location { continue; }
+		if re != nil && !re.MatchString(info.Device) &&
+			!re.MatchString(info.Description) &&
+			!re.MatchString(info.HWID()) {
+			continue
+		}
+		found = append(found, info)
+	}
+	return found, nil
+}
+
+// FindPort returns the one port that matches and fails if there are
+// none or more than one
+func FindPort(sel Selector) (info PortInfo, e error) {
+	ports, e := ListPorts()
+	if e != nil { return info, e; }
+	found, e := sel.Filter(ports)
+	if e != nil { return info, e; }
+	switch len(found) {
+	case 0:
+		return info, NewPortError("No port matches %+v", sel)
+	case 1:
+		return found[0], nil
+	}
+	var devices []string
+	for _, info := range found {
+		devices = append(devices, info.Device)
+	}
+	return info, NewPortError("%d ports match %+v: %s", len(found), sel,
+				  strings.Join(devices, ", "))
+}
+
+// OpenMatching opens the port FindPort() finds with DefaultPortConfig()
+func OpenMatching(sel Selector) (*Port, error) {
+	return OpenMatchingWithConfig(sel, DefaultPortConfig())
+}
+func OpenMatchingWithConfig(sel Selector, cfg PortConfig) (*Port, error) {
+	info, e := FindPort(sel)
+	if e != nil { return nil, e; }
+	return OpenWithConfig(info.Device, cfg)
+}
+
+/* EOF */
@@ -0,0 +1,150 @@
+package sio
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// serial_struct flags, see linux/tty_flags.h
+const (
+	ASYNC_SPD_HI uint32 = 0x0010
+	ASYNC_SPD_VHI uint32 = 0x0020
+	ASYNC_SKIP_TEST uint32 = 0x0040
+	ASYNC_SPD_SHI uint32 = 0x1000
+	ASYNC_LOW_LATENCY uint32 = 0x2000
+	ASYNC_SPD_CUST uint32 = 0x0030
+	ASYNC_SPD_MASK uint32 = 0x1030
+)
+
+// closing_wait special values, in 1/100 s like the rest of it
+const (
+	ASYNC_CLOSING_WAIT_INF = 0
+	ASYNC_CLOSING_WAIT_NONE = 65535
+)
+
+// struct serial_struct, see linux/serial.h;
+// Go lays it out the way C does on every arch Go runs linux on
+type serial_struct struct {
+	typ int32
+	line int32
+	port uint32
+	irq int32
+	flags int32
+	xmit_fifo_size int32
+	custom_divisor int32
+	baud_base int32
+	close_delay uint16
+	io_type int8
+	reserved_char int8
+	hub6 int32
+	closing_wait uint16
+	closing_wait2 uint16
+	iomem_base uintptr
+	iomem_reg_shift uint16
+	port_high uint32
+	iomap_base uintptr
+}
+
+// SerialInfo is what TIOCGSERIAL tells about the UART.
+// Close_delay and Closing_wait are in 1/100 s.
+type SerialInfo struct {
+	Type, Line int32
+	Port uint32
+	Irq int32
+	Flags uint32
+	Xmit_fifo_size int32
+	Baud_base, Custom_divisor int32
+	Close_delay, Closing_wait uint16
+}
+
+func (fd *Ioctl) TIOCGSERIAL() (ss serial_struct, e error) {
+	_, _, err := fd.ioctl(syscall.TIOCGSERIAL, uintptr(unsafe.Pointer(&ss)))
+	if err != E_OK {
+		return ss, NewPortError("ioctl(%v, TIOCGSERIAL, *): %v", *fd, err)
+	}
+	return ss, nil
+}
+func (fd *Ioctl) TIOCSSERIAL(ss serial_struct) (e error) {
+	_, _, err := fd.ioctl(syscall.TIOCSSERIAL, uintptr(unsafe.Pointer(&ss)))
+	if err != E_OK {
+		return NewPortError("ioctl(%v, TIOCSSERIAL, *): %v", *fd, err)
+	}
+	return nil
+}
+
+func (fd *Ioctl) LowLatency() (bool, error) {
+	ss, e := fd.TIOCGSERIAL()
+	if e != nil { return false, e; }
+	return uint32(ss.flags) & ASYNC_LOW_LATENCY != 0, nil
+}
+func (fd *Ioctl) SetLowLatency(set bool) (e error) {
+	ss, e := fd.TIOCGSERIAL()
+	if e != nil { return e; }
+	if set {
+		ss.flags |= int32(ASYNC_LOW_LATENCY)
+	} else {
+		ss.flags &= ^int32(ASYNC_LOW_LATENCY)
+	}
+	return fd.TIOCSSERIAL(ss)
+}
+
+func (self *Port) GetSerialInfo() (info SerialInfo, e error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return info, PortNotOpenError; }
+	ss, e := self.fd.TIOCGSERIAL()
+	if e != nil {
+		return info, e
+	}
+	return SerialInfo{
+		Type: ss.typ,
+		Line: ss.line,
+		Port: ss.port,
+		Irq: ss.irq,
+		Flags: uint32(ss.flags),
+		Xmit_fifo_size: ss.xmit_fifo_size,
+		Baud_base: ss.baud_base,
+		Custom_divisor: ss.custom_divisor,
+		Close_delay: ss.close_delay,
+		Closing_wait: ss.closing_wait,
+	}, nil
+}
+
+// SetSerialInfo writes info over what the driver has; the fields
+// SerialInfo does not have are left as they are.
+// Most drivers want CAP_SYS_ADMIN for anything but the flags they
+// consider user-settable.
+func (self *Port) SetSerialInfo(info SerialInfo) error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return PortNotOpenError; }
+	ss, e := self.fd.TIOCGSERIAL()
+	if e != nil { return e; }
+	ss.typ = info.Type
+	ss.line = info.Line
+	ss.port = info.Port
+	ss.irq = info.Irq
+	ss.flags = int32(info.Flags)
+	ss.xmit_fifo_size = info.Xmit_fifo_size
+	ss.baud_base = info.Baud_base
+	ss.custom_divisor = info.Custom_divisor
+	ss.close_delay = info.Close_delay
+	ss.closing_wait = info.Closing_wait
+	return self.fd.TIOCSSERIAL(ss)
+}
+
+// LowLatency tells if the driver pushes input to the tty at once
+func (self *Port) LowLatency() (bool, error) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return false, PortNotOpenError; }
+	return self.fd.LowLatency()
+}
+func (self *Port) SetLowLatency(set bool) error {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if !self.IsOpen() { return PortNotOpenError; }
+	return self.fd.SetLowLatency(set)
+}
+
+/* EOF */
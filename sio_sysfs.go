@@ -54,27 +54,12 @@ func (self *SysFS) locate(startpath string, major, minor uint64, seen map[string
 				symlinks = append(symlinks, rpath)
 			}
 		} else if filepath.Base(path) == "dev" {
-			bytes, e := ioutil.ReadFile(path)
+			mjr, mnr, e := readDevFile(path)
 			if e != nil {
-				fmt.Println("Read(", path, "):", e)
+				fmt.Fprintln(os.Stderr, e)
 				return nil
 			}
-			text := string(bytes)
-			tmp := strings.Split(strings.TrimSpace(text), ":")
-			assertb(len(tmp) == 2, "Bad %+q: %+q", path, text)
-
-			mjr, e := strconv.ParseInt(tmp[0], 10, 16)
-			if e != nil {
-				fmt.Fprintf(os.Stderr, "%s: %v", path, text)
-				return nil
-
-			}
-			mnr, e := strconv.ParseInt(tmp[1], 10, 16)
-			if e != nil {
-				fmt.Fprintf(os.Stderr, "%s: %v", path, text)
-				return nil
-			}
-			if major == uint64(mjr) && minor == uint64(mnr) {
+			if major == mjr && minor == mnr {
 				// fmt.Println("has dev", path)
 				ls = append(ls, filepath.Dir(path))
 			}
@@ -93,6 +78,28 @@ func (self *SysFS) Locate(class string, major, minor uint64) []string {
 			   make(map[string]bool))
 }
 
+// readDevFile parses a sysfs "dev" file: "major:minor\n"
+func readDevFile(path string) (major, minor uint64, e error) {
+	bytes, e := ioutil.ReadFile(path)
+	if e != nil {
+		return 0, 0, e
+	}
+	text := string(bytes)
+	tmp := strings.Split(strings.TrimSpace(text), ":")
+	if len(tmp) != 2 {
+		return 0, 0, NewPortError("Bad %+q: %+q", path, text)
+	}
+	major, e = strconv.ParseUint(tmp[0], 10, 32)
+	if e != nil {
+		return 0, 0, NewPortError("%s: %+q", path, text)
+	}
+	minor, e = strconv.ParseUint(tmp[1], 10, 32)
+	if e != nil {
+		return 0, 0, NewPortError("%s: %+q", path, text)
+	}
+	return major, minor, nil
+}
+
 func readlink(link string) (path string, e error) {
 	rpath, e := os.Readlink(link)
 	if e != nil {
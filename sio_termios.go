@@ -1,9 +1,16 @@
 package sio
 
 import "syscall"
+import "time"
 
 const CMSPAR uint32 = 010000000000 // linux, octal!
 const CRTSCTS uint32 = 020000000000	/* flow control */
+const CBAUD uint32 = 0010017 // linux, octal!
+const CIBAUD uint32 = 002003600000 // input speed, CBAUD << IBSHIFT
+const BOTHER uint32 = 0010000 // speed is in Ispeed/Ospeed of termios2
+const IBSHIFT = 16
+const VTimeUnit = 100 * time.Millisecond
+const HUPCL uint32 = 0002000 // drop DTR and RTS on last close
 
 type CharSize uint
 const (
@@ -60,75 +67,92 @@ func IsValidStopBits(v int) bool {
 	return false
 }
 
+// BitRate is in bits per second. The BIT_RATE_Bxxx ones are the standard
+// termios speeds, anything else is set up via BOTHER (see Ioctl.TcSetSpeed).
 type BitRate uint32
 const (
-	BIT_RATE_B0 = BitRate(syscall.B0)
-	BIT_RATE_B50 = BitRate(syscall.B50)
-	BIT_RATE_B75 = BitRate(syscall.B75)
-	BIT_RATE_B110 = BitRate(syscall.B110)
-	BIT_RATE_B134 = BitRate(syscall.B134)
-	BIT_RATE_B150 = BitRate(syscall.B150)
-	BIT_RATE_B200 = BitRate(syscall.B200)
-	BIT_RATE_B300 = BitRate(syscall.B300)
-	BIT_RATE_B600 = BitRate(syscall.B600)
-	BIT_RATE_B1200 = BitRate(syscall.B1200)
-	BIT_RATE_B1800 = BitRate(syscall.B1800)
-	BIT_RATE_B2400 = BitRate(syscall.B2400)
-	BIT_RATE_B4800 = BitRate(syscall.B4800)
-	BIT_RATE_B9600 = BitRate(syscall.B9600)
-	BIT_RATE_B19200 = BitRate(syscall.B19200)
-	BIT_RATE_B38400 = BitRate(syscall.B38400)
-	BIT_RATE_B57600 = BitRate(syscall.B57600)
-	BIT_RATE_B115200 = BitRate(syscall.B115200)
-	BIT_RATE_B230400 = BitRate(syscall.B230400)
-	BIT_RATE_B460800 = BitRate(syscall.B460800)
-	BIT_RATE_B500000 = BitRate(syscall.B500000)
-	BIT_RATE_B576000 = BitRate(syscall.B576000)
-	BIT_RATE_B921600 = BitRate(syscall.B921600)
-	BIT_RATE_B1000000 = BitRate(syscall.B1000000)
-	BIT_RATE_B1152000 = BitRate(syscall.B1152000)
-	BIT_RATE_B1500000 = BitRate(syscall.B1500000)
-	BIT_RATE_B2000000 = BitRate(syscall.B2000000)
-	BIT_RATE_B2500000 = BitRate(syscall.B2500000)
-	BIT_RATE_B3000000 = BitRate(syscall.B3000000)
-	BIT_RATE_B3500000 = BitRate(syscall.B3500000)
-	BIT_RATE_B4000000 = BitRate(syscall.B4000000)
+	BIT_RATE_B0 = BitRate(0)
+	BIT_RATE_B50 = BitRate(50)
+	BIT_RATE_B75 = BitRate(75)
+	BIT_RATE_B110 = BitRate(110)
+	BIT_RATE_B134 = BitRate(134)
+	BIT_RATE_B150 = BitRate(150)
+	BIT_RATE_B200 = BitRate(200)
+	BIT_RATE_B300 = BitRate(300)
+	BIT_RATE_B600 = BitRate(600)
+	BIT_RATE_B1200 = BitRate(1200)
+	BIT_RATE_B1800 = BitRate(1800)
+	BIT_RATE_B2400 = BitRate(2400)
+	BIT_RATE_B4800 = BitRate(4800)
+	BIT_RATE_B9600 = BitRate(9600)
+	BIT_RATE_B19200 = BitRate(19200)
+	BIT_RATE_B38400 = BitRate(38400)
+	BIT_RATE_B57600 = BitRate(57600)
+	BIT_RATE_B115200 = BitRate(115200)
+	BIT_RATE_B230400 = BitRate(230400)
+	BIT_RATE_B460800 = BitRate(460800)
+	BIT_RATE_B500000 = BitRate(500000)
+	BIT_RATE_B576000 = BitRate(576000)
+	BIT_RATE_B921600 = BitRate(921600)
+	BIT_RATE_B1000000 = BitRate(1000000)
+	BIT_RATE_B1152000 = BitRate(1152000)
+	BIT_RATE_B1500000 = BitRate(1500000)
+	BIT_RATE_B2000000 = BitRate(2000000)
+	BIT_RATE_B2500000 = BitRate(2500000)
+	BIT_RATE_B3000000 = BitRate(3000000)
+	BIT_RATE_B3500000 = BitRate(3500000)
+	BIT_RATE_B4000000 = BitRate(4000000)
 )
+// IsValidSpeed reports whether v is one of the standard termios speeds
 func IsValidSpeed(v uint32) bool {
-	switch BitRate(v) {
-	case BIT_RATE_B0: return true
-	case BIT_RATE_B50: return true
-	case BIT_RATE_B75: return true
-	case BIT_RATE_B110: return true
-	case BIT_RATE_B134: return true
-	case BIT_RATE_B150: return true
-	case BIT_RATE_B200: return true
-	case BIT_RATE_B300: return true
-	case BIT_RATE_B600: return true
-	case BIT_RATE_B1200: return true
-	case BIT_RATE_B1800: return true
-	case BIT_RATE_B2400: return true
-	case BIT_RATE_B4800: return true
-	case BIT_RATE_B9600: return true
-	case BIT_RATE_B19200: return true
-	case BIT_RATE_B38400: return true
-	case BIT_RATE_B57600: return true
-	case BIT_RATE_B115200: return true
-	case BIT_RATE_B230400: return true
-	case BIT_RATE_B460800: return true
-	case BIT_RATE_B500000: return true
-	case BIT_RATE_B576000: return true
-	case BIT_RATE_B921600: return true
-	case BIT_RATE_B1000000: return true
-	case BIT_RATE_B1152000: return true
-	case BIT_RATE_B1500000: return true
-	case BIT_RATE_B2000000: return true
-	case BIT_RATE_B2500000: return true
-	case BIT_RATE_B3000000: return true
-	case BIT_RATE_B3500000: return true
-	case BIT_RATE_B4000000: return true
+	_, ok := SysBitRate[BitRate(v)]
+	return ok
+}
+var SysBitRate = map[BitRate]uint32{
+	BIT_RATE_B0: syscall.B0,
+	BIT_RATE_B50: syscall.B50,
+	BIT_RATE_B75: syscall.B75,
+	BIT_RATE_B110: syscall.B110,
+	BIT_RATE_B134: syscall.B134,
+	BIT_RATE_B150: syscall.B150,
+	BIT_RATE_B200: syscall.B200,
+	BIT_RATE_B300: syscall.B300,
+	BIT_RATE_B600: syscall.B600,
+	BIT_RATE_B1200: syscall.B1200,
+	BIT_RATE_B1800: syscall.B1800,
+	BIT_RATE_B2400: syscall.B2400,
+	BIT_RATE_B4800: syscall.B4800,
+	BIT_RATE_B9600: syscall.B9600,
+	BIT_RATE_B19200: syscall.B19200,
+	BIT_RATE_B38400: syscall.B38400,
+	BIT_RATE_B57600: syscall.B57600,
+	BIT_RATE_B115200: syscall.B115200,
+	BIT_RATE_B230400: syscall.B230400,
+	BIT_RATE_B460800: syscall.B460800,
+	BIT_RATE_B500000: syscall.B500000,
+	BIT_RATE_B576000: syscall.B576000,
+	BIT_RATE_B921600: syscall.B921600,
+	BIT_RATE_B1000000: syscall.B1000000,
+	BIT_RATE_B1152000: syscall.B1152000,
+	BIT_RATE_B1500000: syscall.B1500000,
+	BIT_RATE_B2000000: syscall.B2000000,
+	BIT_RATE_B2500000: syscall.B2500000,
+	BIT_RATE_B3000000: syscall.B3000000,
+	BIT_RATE_B3500000: syscall.B3500000,
+	BIT_RATE_B4000000: syscall.B4000000,
+}
+
+func sysSpeed(speed BitRate) uint32 {
+	if code, ok := SysBitRate[speed]; ok {
+		return code
 	}
-	return false
+	return BOTHER
+}
+
+// needsTermios2 tells if the speeds cannot be set with plain TCSETS
+func needsTermios2(ispeed, ospeed BitRate) bool {
+	return sysSpeed(ospeed) == BOTHER ||
+		(ispeed != BIT_RATE_B0 && sysSpeed(ispeed) == BOTHER)
 }
 
 func setTermios(termios *syscall.Termios, port *Port) (e error) {
@@ -139,9 +163,12 @@ func setTermios(termios *syscall.Termios, port *Port) (e error) {
 	}()
 
 	var vmin, vtime uint8
-	if port.inter_byte_timeout > 0. {
+	if port.inter_byte_timeout > 0 {
 		vmin = 1
-		vtime = uint8(port.inter_byte_timeout * 10.)
+		vtime = 255
+		if t := port.inter_byte_timeout / VTimeUnit; t < 255 {
+			vtime = uint8(t) // 0 is still better than nothing
+		}
 	}
 
 	termios.Lflag &= ^uint32(syscall.ICANON | syscall.ECHO    | syscall.ECHOE |
@@ -153,6 +180,10 @@ func setTermios(termios *syscall.Termios, port *Port) (e error) {
 	termios.Iflag &= ^uint32(syscall.INLCR  | syscall.IGNCR | syscall.ICRNL |
 				 syscall.IGNBRK | syscall.IUCLC | syscall.PARMRK|
 				 syscall.INPCK  | syscall.ISTRIP)
+	if port.mark_errors { // see parmrkDecoder
+		termios.Iflag &= ^uint32(syscall.IGNPAR | syscall.BRKINT)
+		termios.Iflag |= syscall.INPCK | syscall.PARMRK
+	}
 	switch port.parity {
 	case PARITY_NONE:
 		termios.Cflag &= ^uint32(syscall.PARENB | syscall.PARODD | CMSPAR)
@@ -184,10 +215,20 @@ func setTermios(termios *syscall.Termios, port *Port) (e error) {
 		termios.Cflag &= ^uint32(CRTSCTS)
 	}
 
-	termios.Ispeed = uint32(port.speed)
-	termios.Ospeed = uint32(port.speed)
+	// the kernel ignores Ispeed/Ospeed here, the speed goes into Cflag;
+	// for BOTHER the actual rate is set by Ioctl.TcSetSpeed() later on
+	termios.Cflag &= ^uint32(CBAUD | CIBAUD)
+	termios.Cflag |= sysSpeed(port.speed)
+	if port.ispeed != BIT_RATE_B0 {
+		termios.Cflag |= sysSpeed(port.ispeed) << IBSHIFT
+	}
 
 	termios.Cflag |= syscall.CLOCAL | syscall.CREAD
+	if port.hupcl {
+		termios.Cflag |= HUPCL
+	} else {
+		termios.Cflag &= ^HUPCL
+	}
 	termios.Cflag &= ^uint32(syscall.CSIZE)
 	termios.Cflag |= SysCharSize[port.char_size]
 	switch port.stop_bits {
@@ -1,8 +1,14 @@
 package sio
 
+import "context"
 import "fmt"
+import "io"
+import "net"
+import "net/url"
 import "strings"
 import "testing"
+import "time"
+import "unsafe"
 
 import (
 	"os"
@@ -10,7 +16,16 @@ import (
 	"syscall"
 )
 
-const USB0 = "/dev/ttyUSB0"
+// testSelector picks the modem TestMain talks to: SIO_TEST_PORT is a regexp
+// over the device, description and hwid, the only USB adapter by default
+var testSelector = Selector{Pattern: testPattern()}
+
+func testPattern() string {
+	if pattern := os.Getenv("SIO_TEST_PORT"); pattern != "" {
+		return pattern
+	}
+	return "^USB "
+}
 
 var tty *Console
 var port *Port
@@ -59,13 +74,20 @@ func userEnd(end StopChannel, com CommandChannel) {
 }
 
 func TestMain(t *testing.T) {
+	info, e := FindPort(testSelector)
+	if e != nil {
+		t.Skip(e) // takes a modem
+	}
 	printf("begin")
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
 	go sighand(sig)
 
-	port = NewSerialPort(USB0)
+	port, e = OpenWithConfig(info.Device, DefaultPortConfig())
+	if e != nil {
+		t.Fatal(e)
+	}
 	defer func() { port.Close(); printf("port closed"); }()
 	printf("Port is open: %s", port)
 
@@ -88,4 +110,779 @@ func TestMain(t *testing.T) {
 	printf("the end")
 }
 
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]string{
+		"115200 8N1": "115200 8N1",
+		"9600,7E2": "9600 7E2",
+		"19200/8-O-1": "19200 8O1",
+		"9600,8,n,1": "9600 8N1",
+		"250000": "250000 8N1",
+	} {
+		mode, e := ParseMode(s)
+		if e != nil {
+			t.Errorf("ParseMode(%+q): %v", s, e)
+		} else if mode.String() != want {
+			t.Errorf("ParseMode(%+q) = %+q, want %+q", s, mode, want)
+		}
+	}
+	for _, s := range []string{"", "8N1", "9600 9N1", "9600 8X1", "9600 8N3", "9600 8N1.5"} {
+		if _, e := ParseMode(s); e == nil {
+			t.Errorf("ParseMode(%+q): no error", s)
+		}
+	}
+}
+
+func TestParmrkDecoder(t *testing.T) {
+	var found []LineError
+	var report = func(le LineError) { found = append(found, le) }
+	var d parmrkDecoder
+
+	in := []byte("a\xff\xffb\xff\x00\x00c\xff")
+	n := d.decode(in, report)
+	if got := string(in[:n]); got != "a\xffbc" {
+		t.Errorf("decode: %+q", got)
+	}
+	in = []byte("\x00Xd") // the mark is split across reads
+	n = d.decode(in, report)
+	if got := string(in[:n]); got != "d" {
+		t.Errorf("decode: %+q", got)
+	}
+	want := []LineError{{3, LINE_BREAK, 0}, {4, LINE_ERROR, 'X'}}
+	if len(found) != len(want) || found[0] != want[0] || found[1] != want[1] {
+		t.Errorf("found %+v, want %+v", found, want)
+	}
+}
+
+func TestSerialStructSize(t *testing.T) {
+	// what the kernel has on 64-bit arches
+	if unsafe.Sizeof(uintptr(0)) == 8 && unsafe.Sizeof(serial_struct{}) != 72 {
+		t.Errorf("sizeof(serial_struct) = %d, want 72", unsafe.Sizeof(serial_struct{}))
+	}
+}
+
+func TestRs485RoundTrip(t *testing.T) {
+	if unsafe.Sizeof(serial_rs485{}) != 32 {
+		t.Errorf("sizeof(serial_rs485) = %d, want 32", unsafe.Sizeof(serial_rs485{}))
+	}
+	want := Rs485{
+		Enabled: true,
+		Rts_level_for_tx: true,
+		Delay_before_tx: 2 * time.Millisecond,
+		Terminate_bus: true,
+		Addr_dest: true,
+		Addr_dest_value: 0x42,
+	}
+	var rs serial_rs485
+	want.update(&rs)
+	if got := newRs485(rs); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	want.Delay_before_rx = 1500 * time.Microsecond // rounded up
+	want.update(&rs)
+	if rs.delay_rts_after_send != 2 {
+		t.Errorf("delay_rts_after_send = %d, want 2", rs.delay_rts_after_send)
+	}
+	want.Enabled = false
+	want.update(&rs)
+	if rs.flags != 0 {
+		t.Errorf("flags = %#x after disable", rs.flags)
+	}
+}
+
+func TestStripEcho(t *testing.T) {
+	var p Port
+	p.expect_echo([]byte("hello"))
+	p.unexpect_echo(1) // "o" has not gone out
+	buf := []byte("he")
+	if n := p.strip_echo(buf); n != 0 {
+		t.Errorf("strip_echo(he) = %d, want 0", n)
+	}
+	buf = []byte("llxy")
+	if n := p.strip_echo(buf); string(buf[:n]) != "xy" {
+		t.Errorf("strip_echo(llxy) = %q, want \"xy\"", buf[:n])
+	}
+	p.expect_echo([]byte("ab"))
+	buf = []byte("zab")
+	if n := p.strip_echo(buf); string(buf[:n]) != "zab" || p.echo != nil {
+		t.Errorf("strip_echo(zab) = %q, echo %q", buf[:n], p.echo)
+	}
+}
+
+func TestReadUsb(t *testing.T) {
+	root := t.TempDir()
+	usb := root + "/bus/usb"
+	dev := root + "/devices/usb1/1-1.2"
+	port := dev + "/1-1.2:1.0/ttyUSB0"
+	os.MkdirAll(usb, 0755)
+	os.MkdirAll(root + "/bus/usb-serial", 0755)
+	os.MkdirAll(port, 0755)
+	os.Symlink(usb, dev + "/subsystem")
+	os.Symlink(usb, dev + "/1-1.2:1.0/subsystem")
+	os.Symlink(root + "/bus/usb-serial", port + "/subsystem")
+	for name, value := range map[string]string{
+		dev + "/idVendor": "0403\n",
+		dev + "/idProduct": "6001\n",
+		dev + "/serial": "A50285BI\n",
+		dev + "/product": "FT232R USB UART\n",
+		dev + "/1-1.2:1.0/bInterfaceNumber": "00\n",
+	} {
+		os.WriteFile(name, []byte(value), 0644)
+	}
+
+	var info PortInfo
+	info.readUsb(port)
+	if !info.Usb || info.VID != 0x0403 || info.PID != 0x6001 {
+		t.Fatalf("readUsb: %+v", info)
+	}
+	if hwid := info.HWID(); hwid != "USB VID:PID=0403:6001 SER=A50285BI LOCATION=1-1.2:1.0" {
+		t.Errorf("HWID() = %q", hwid)
+	}
+}
+
+func TestSelectorFilter(t *testing.T) {
+	ports := []PortInfo{
+		{Device: "/dev/ttyS0", Description: "ttyS0 (serial)"},
+		{Device: "/dev/ttyUSB0", Description: "FT232R USB UART (ftdi_sio)",
+			Usb: true, VID: 0x0403, PID: 0x6001, Serial_number: "A10K3X", Location: "1-1.2"},
+		{Device: "/dev/ttyUSB1", Description: "FT232R USB UART (ftdi_sio)",
+			Usb: true, VID: 0x0403, PID: 0x6001, Serial_number: "B20L4Y", Location: "1-1.3"},
+		{Device: "/dev/ttyACM0", Description: "Arduino Uno (cdc_acm)",
+			Usb: true, VID: 0x2341, PID: 0x0043, Location: "1-4", Interface: 0},
+	}
+	for _, c := range []struct {
+		sel Selector
+		want []string
+	}{
+		{Selector{VID: 0x0403, Serial: "A10K3X"}, []string{"/dev/ttyUSB0"}},
+		{Selector{VID: 0x0403}, []string{"/dev/ttyUSB0", "/dev/ttyUSB1"}},
+		{Selector{Pattern: "VID:PID=2341:"}, []string{"/dev/ttyACM0"}},
+		{Selector{Pattern: "^USB "}, []string{"/dev/ttyUSB0", "/dev/ttyUSB1", "/dev/ttyACM0"}},
+		{Selector{Location: "1-1.3"}, []string{"/dev/ttyUSB1"}},
+		{Selector{Pattern: "ttyS"}, []string{"/dev/ttyS0"}},
+		{Selector{PID: 0x1234}, nil},
+	} {
+		found, e := c.sel.Filter(ports)
+		if e != nil {
+			t.Fatalf("%+v: %v", c.sel, e)
+		}
+		var got []string
+		for _, info := range found {
+			got = append(got, info.Device)
+		}
+		if strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("%+v: got %v, want %v", c.sel, got, c.want)
+		}
+	}
+	if _, e := (Selector{Pattern: "("}).Filter(ports); e == nil {
+		t.Errorf("bad pattern: no error")
+	}
+}
+
+// ueventFeed is a UeventSource for tests
+type ueventFeed chan []byte
+
+func (self ueventFeed) ReadUevent() ([]byte, error) {
+	msg, ok := <- self
+	if !ok {
+		return nil, WatcherClosedError
+	}
+	if msg == nil {
+		return nil, UeventsLostError
+	}
+	return msg, nil
+}
+func (self ueventFeed) Close() error {
+	return nil
+}
+
+func uevent(fields ...string) []byte {
+	return []byte(strings.Join(fields, "\x00") + "\x00")
+}
+
+func TestWatcher(t *testing.T) {
+	feed := make(ueventFeed, 5)
+	w := NewWatcherWithSource(feed)
+	defer w.Close()
+
+	feed <- uevent("add@/devices/virtual/net/lo", "ACTION=add", "SUBSYSTEM=net")
+	feed <- uevent("add@/devices/nowhere/ttyUSB7", "ACTION=add",
+		"DEVPATH=/devices/nowhere/ttyUSB7", "SUBSYSTEM=tty",
+		"MAJOR=188", "MINOR=7", "DEVNAME=ttyUSB7")
+	feed <- uevent("change@/devices/nowhere/ttyUSB7", "ACTION=change",
+		"SUBSYSTEM=tty", "DEVNAME=ttyUSB7")
+	feed <- nil // ENOBUFS
+	feed <- uevent("remove@/devices/nowhere/ttyUSB7", "ACTION=remove",
+		"DEVPATH=/devices/nowhere/ttyUSB7", "SUBSYSTEM=tty",
+		"MAJOR=188", "MINOR=7", "DEVNAME=ttyUSB7")
+	close(feed)
+
+	var got []string
+	for event := range w.Events() {
+		got = append(got, fmt.Sprintf("%v %s %d:%d", event.Action,
+			event.Port.Device, event.Port.Major, event.Port.Minor))
+	}
+	want := []string{"add /dev/ttyUSB7 188:7", "resync  0:0", "remove /dev/ttyUSB7 188:7"}
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStableNames(t *testing.T) {
+	root := t.TempDir()
+	device := root + "/ttyUSB3"
+	os.WriteFile(device, nil, 0644)
+	os.WriteFile(root + "/ttyUSB4", nil, 0644)
+	os.MkdirAll(root + "/serial/by-id", 0755)
+	os.MkdirAll(root + "/serial/by-path", 0755)
+	byid := root + "/serial/by-id/usb-FTDI_FT232R_USB_UART_A10K3X-if00-port0"
+	bypath := root + "/serial/by-path/pci-0000:00:14.0-usb-0:2:1.0-port0"
+	os.Symlink("../../ttyUSB3", byid)
+	os.Symlink("../../ttyUSB3", bypath)
+	os.Symlink("../../ttyUSB4", root + "/serial/by-id/usb-FTDI_other-if00-port0")
+
+	by_id, by_path := stableNames(root + "/serial", device)
+	if len(by_id) != 1 || by_id[0] != byid || len(by_path) != 1 || by_path[0] != bypath {
+		t.Errorf("stableNames: %q %q", by_id, by_path)
+	}
+	if got := stableName(by_id, by_path); got != byid {
+		t.Errorf("stableName = %q", got)
+	}
+	for _, path := range []string{byid, "usb-FTDI_FT232R_USB_UART_A10K3X-if00-port0"} {
+		if got, e := resolvePath(root + "/serial", path); e != nil || got != device {
+			t.Errorf("resolvePath(%q) = %q, %v", path, got, e)
+		}
+	}
+}
+
+func TestModemChanged(t *testing.T) {
+	last := ModemStatus{CTS: true, DSR: true}
+	for _, c := range []struct {
+		now ModemStatus
+		prev, counters LineCounters
+		mask, want ModemLine
+	}{
+		{ModemStatus{CTS: true, DSR: true}, LineCounters{}, LineCounters{}, MODEM_INPUTS, 0},
+		{ModemStatus{CTS: false, DSR: true}, LineCounters{}, LineCounters{}, MODEM_INPUTS, MODEM_CTS},
+		{ModemStatus{CTS: false, DSR: true}, LineCounters{}, LineCounters{}, MODEM_DSR, 0},
+		{ModemStatus{CTS: true, DSR: true, CD: true}, LineCounters{}, LineCounters{}, MODEM_CD, MODEM_CD},
+		// RI went up and down again between two polls
+		{ModemStatus{CTS: true, DSR: true}, LineCounters{Rng: 4}, LineCounters{Rng: 6}, MODEM_INPUTS, MODEM_RI},
+		// the counter wrapped around
+		{ModemStatus{CTS: true, DSR: true}, LineCounters{Dcd: 0xffffffff}, LineCounters{Dcd: 1}, MODEM_CD | MODEM_CTS, MODEM_CD},
+		// outputs are not inputs
+		{ModemStatus{CTS: true, DSR: true, DTR: true, RTS: true}, LineCounters{}, LineCounters{}, MODEM_INPUTS, 0},
+	} {
+		moved := countersMoved(c.prev, c.counters)
+		if got := modemChanged(last, c.now, moved, c.mask); got != c.want {
+			t.Errorf("%v -> %v mask %#x: got %#x, want %#x", last, c.now, c.mask, got, c.want)
+		}
+	}
+}
+
+func TestSetTermiosSpeed(t *testing.T) {
+	for _, c := range []struct {
+		speed, ispeed BitRate
+		cbaud, cibaud uint32
+		termios2 bool
+	}{
+		{115200, 0, syscall.B115200, 0, false},
+		{9600, 9600, syscall.B9600, syscall.B9600, false},
+		{9600, 1200, syscall.B9600, syscall.B1200, false},
+		{250000, 0, BOTHER, 0, true},
+		{74880, 74880, BOTHER, BOTHER, true},
+		{9600, 250000, syscall.B9600, BOTHER, true},
+		{250000, 9600, BOTHER, syscall.B9600, true},
+	} {
+		// whatever was there before must go
+		termios := syscall.Termios{Cflag: CBAUD | CIBAUD}
+		port := &Port{speed: c.speed, ispeed: c.ispeed,
+			      char_size: CHAR_SIZE_8, stop_bits: STOP_BITS_1}
+		if e := setTermios(&termios, port); e != nil {
+			t.Fatalf("setTermios(%v/%v): %v", c.ispeed, c.speed, e)
+		}
+		cbaud, cibaud := termios.Cflag & CBAUD, (termios.Cflag & CIBAUD) >> IBSHIFT
+		if cbaud != c.cbaud || cibaud != c.cibaud {
+			t.Errorf("%v/%v: CBAUD %#o CIBAUD %#o, want %#o %#o",
+				 c.ispeed, c.speed, cbaud, cibaud, c.cbaud, c.cibaud)
+		}
+		if got := needsTermios2(c.ispeed, c.speed); got != c.termios2 {
+			t.Errorf("needsTermios2(%v, %v) = %v", c.ispeed, c.speed, got)
+		}
+	}
+}
+
+// a pty takes any speed and knows nothing of RS485: a good way to fail
+func TestApplyConfigRollback(t *testing.T) {
+	cfg := DefaultPortConfig()
+	cfg.Speed = 250000
+	m, e := OpenPty(cfg)
+	if e != nil {
+		t.Skip(e)
+	}
+	defer m.Close()
+
+	cfg = m.Config()
+	cfg.Speed = 74880
+	cfg.Rs485.Enabled = true
+	if e = m.ApplyConfig(cfg); e == nil {
+		t.Fatal("ApplyConfig with RS485 on a pty: no error")
+	}
+	ispeed, ospeed, e := m.ActualSpeed()
+	if e != nil || ispeed != 250000 || ospeed != 250000 || m.Config().Speed != 250000 {
+		t.Errorf("after rollback: %v/%v %v, Config().Speed %v", ispeed, ospeed, e, m.Config().Speed)
+	}
+}
+
+func TestFailedOpenLeaksNothing(t *testing.T) {
+	m, e := OpenPty(DefaultPortConfig())
+	if e != nil {
+		t.Skip(e)
+	}
+	defer m.Close()
+
+	var fds = func() int {
+		entries, _ := os.ReadDir("/proc/self/fd")
+		return len(entries)
+	}
+	cfg := DefaultPortConfig()
+	cfg.Exclusive = false
+	cfg.Rs485.Enabled = true // no RS485 on a pty
+	before := fds()
+	for i := 0; i < 3; i++ {
+		if p, e := OpenWithConfig(m.Peer(), cfg); e == nil {
+			p.Close()
+			t.Fatal("RS485 on a pty: no error")
+		}
+	}
+	if after := fds(); after != before {
+		t.Errorf("%d fds before failed opens, %d after", before, after)
+	}
+}
+
+func TestRfc2217NonBlockingRead(t *testing.T) {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Skip(e)
+	}
+	defer l.Close()
+	conn, e := net.Dial("tcp", l.Addr().String())
+	if e != nil {
+		t.Fatal(e)
+	}
+	server, e := l.Accept()
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer server.Close()
+	port := &rfc2217Port{netPort: &netPort{conn: conn, read_timeout: NonBlockingTimeout}}
+	defer port.Close()
+
+	buf := make([]byte, 16)
+	if n, e := port.Read(buf); n != 0 || e != nil {
+		t.Errorf("nothing there: %d, %v", n, e)
+	}
+	server.Write([]byte{TELNET_IAC, TELNET_WILL, TELNET_OPT_BINARY})
+	time.Sleep(20 * time.Millisecond)
+	if n, e := port.Read(buf); n != 0 || e != nil {
+		t.Errorf("only commands: %d, %v", n, e)
+	}
+	server.Write([]byte("ok"))
+	time.Sleep(20 * time.Millisecond)
+	if n, e := port.Read(buf); string(buf[:n]) != "ok" || e != nil {
+		t.Errorf("data: %q, %v", buf[:n], e)
+	}
+}
+
+func TestConfigFromQuery(t *testing.T) {
+	var query = func(s string) (cfg PortConfig, e error) {
+		q, e := url.ParseQuery(s)
+		if e != nil {
+			t.Fatal(e)
+		}
+		cfg = DefaultPortConfig()
+		return cfg, ConfigFromQuery(q, &cfg)
+	}
+	cfg, e := query("mode=19200,7E2&rtscts=1&dtr=0&rts=true" +
+			"&timeout=0.5&write_timeout=none&inter_byte_timeout=20ms")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if cfg.Speed != 19200 || cfg.CharSize != CHAR_SIZE_7 || cfg.Parity != PARITY_EVEN ||
+	   cfg.StopBits != STOP_BITS_2 || !cfg.RtsCts || cfg.DTR != LINE_LOW || cfg.RTS != LINE_HIGH ||
+	   cfg.ReadTimeout != 500 * time.Millisecond || cfg.WriteTimeout != InfiniteTimeout ||
+	   cfg.InterByteTimeout != 20 * time.Millisecond {
+		t.Errorf("got %+v", cfg)
+	}
+	// single parameters go over mode=
+	if cfg, e = query("mode=9600,8N1&baud=250000&parity=O"); e != nil ||
+	   cfg.Speed != 250000 || cfg.Parity != PARITY_ODD {
+		t.Errorf("baud= and parity= after mode=: %+v, %v", cfg, e)
+	}
+	for _, s := range []string{"baud=fast", "parity=EO", "parity=X", "bytesize=9",
+				   "xonxoff=maybe", "timeout=soon", "inter_byte_timeout=none"} {
+		if _, e := query(s); e == nil {
+			t.Errorf("%+q: no error", s)
+		}
+	}
+}
+
+func TestLoopURL(t *testing.T) {
+	port, e := OpenURL("loop://?timeout=100ms")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer port.Close()
+
+	if n, e := port.Write([]byte("hello")); n != 5 || e != nil {
+		t.Fatalf("Write: %d, %v", n, e)
+	}
+	buf := make([]byte, 16)
+	if n, e := port.Read(buf); string(buf[:n]) != "hello" || e != nil {
+		t.Errorf("Read: %+q, %v", buf[:n], e)
+	}
+	start := time.Now()
+	if n, e := port.Read(buf); n != 0 || e != PortTimeoutError || time.Since(start) < 100 * time.Millisecond {
+		t.Errorf("Read on empty: %d, %v after %v", n, e, time.Since(start))
+	}
+}
+
+func TestRfc2217Filter(t *testing.T) {
+	conn, server := net.Pipe()
+	defer server.Close()
+	port := &rfc2217Port{netPort: &netPort{conn: conn}}
+	defer port.Close()
+
+	reply := make(chan []byte)
+	go func() {
+		buf := make([]byte, 3)
+		io.ReadFull(server, buf)
+		reply <- buf
+	}()
+	var got []byte
+	for _, raw := range []string{
+		"a\xff", "\xffb", // an escaped IAC
+		"\xff\xfb", "\x01c", // WILL ECHO: we refuse it
+		"\xff\xfa\x2c\x6a\xff", "\xff\x00\xff", "\xf0d", // a notification with IAC in it
+		"\xff\xfd\x00e", // DO BINARY: asked for, no reply
+	} {
+		got = append(got, port.filter([]byte(raw))...)
+	}
+	if string(got) != "a\xffbcde" {
+		t.Errorf("filter: %+q", got)
+	}
+	if buf := <-reply; string(buf) != "\xff\xfe\x01" {
+		t.Errorf("reply to WILL ECHO: %+q", buf)
+	}
+	if port.state != telnetData {
+		t.Errorf("state %d at the end", port.state)
+	}
+}
+
+func TestLineCountersDelta(t *testing.T) {
+	start := time.Now()
+	prev := LineCounters{Time: start, Rx: 0xfffffff0, Tx: 100, Frame: 0xffffffff, Dcd: 7}
+	now := LineCounters{Time: start.Add(time.Second), Rx: 0x10, Tx: 150, Frame: 2, Dcd: 7}
+	delta, elapsed := now.Delta(prev)
+	if delta.Rx != 0x20 || delta.Tx != 50 || delta.Frame != 3 || delta.Dcd != 0 {
+		t.Errorf("Delta: %+v", delta)
+	}
+	if elapsed != time.Second || !delta.Time.Equal(now.Time) {
+		t.Errorf("Delta: elapsed %v, Time %v", elapsed, delta.Time)
+	}
+	if delta.Errors() != 3 {
+		t.Errorf("Errors() = %d", delta.Errors())
+	}
+}
+
+// select(2) cannot do fds past 1023, a busy process has them
+func TestHighFd(t *testing.T) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i := 0; i < 1100; i++ {
+		f, e := os.Open("/dev/null")
+		if e != nil {
+			t.Skip(e)
+		}
+		files = append(files, f)
+	}
+	m, e := OpenPty(DefaultPortConfig())
+	if e != nil {
+		t.Skip(e)
+	}
+	defer m.Close()
+	if m.fd < 1024 {
+		t.Skipf("fd %d", m.fd)
+	}
+	buf := make([]byte, 16)
+	if n, e := m.Read(buf); n != 0 || e != PortTimeoutError {
+		t.Errorf("Read on fd %d: %d, %v", m.fd, n, e)
+	}
+	if n, e := m.Write([]byte("x")); n != 1 || e != nil {
+		t.Errorf("Write on fd %d: %d, %v", m.fd, n, e)
+	}
+}
+
+// a blocking read stands in for TIOCMIWAIT, which a pty does not have
+func TestWakeable(t *testing.T) {
+	var fds [2]int
+	if e := syscall.Pipe(fds[:]); e != nil {
+		t.Skip(e)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	waiter, e := startWakeable(func(stop *int32) error {
+		buf := make([]byte, 1)
+		for !woken(stop) {
+			_, e := syscall.Read(fds[0], buf)
+			if e != syscall.EINTR {
+				return e
+			}
+		}
+		return PortCancelledError
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	time.Sleep(20 * time.Millisecond) // let it block
+	done := make(chan error)
+	go func() { done <- waiter.wake() }()
+	select {
+	case e = <- done:
+		if e != PortCancelledError {
+			t.Errorf("wake: %v", e)
+		}
+	case <- time.After(time.Second):
+		syscall.Write(fds[1], []byte("x"))
+		t.Error("the read was not interrupted")
+	}
+}
+
+func TestCancelRead(t *testing.T) {
+	cfg := DefaultPortConfig()
+	cfg.ReadTimeout = InfiniteTimeout
+	m, e := OpenPty(cfg)
+	if e != nil {
+		t.Skip(e)
+	}
+	defer m.Close()
+
+	done := make(chan error)
+	go func() {
+		_, e := m.Read(make([]byte, 16))
+		done <- e
+	}()
+	time.Sleep(20 * time.Millisecond) // let it block
+	if e = m.CancelRead(); e != nil {
+		t.Fatal(e)
+	}
+	select {
+	case e = <- done:
+		if e != PortCancelledError {
+			t.Errorf("Read: %v", e)
+		}
+	case <- time.After(time.Second):
+		t.Fatal("Read was not cancelled")
+	}
+}
+
+func TestContextIO(t *testing.T) {
+	cfg := DefaultPortConfig()
+	cfg.ReadTimeout = InfiniteTimeout
+	cfg.WriteTimeout = InfiniteTimeout
+	m, e := OpenPty(cfg)
+	if e != nil {
+		t.Skip(e)
+	}
+	defer m.Close()
+	cfg.Exclusive = false
+	s, e := OpenWithConfig(m.Peer(), cfg)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer s.Close()
+
+	var timeout = func() context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+	if n, e := s.ReadContext(timeout(), make([]byte, 16)); n != 0 || e != context.DeadlineExceeded {
+		t.Errorf("ReadContext: %d, %v", n, e)
+	}
+
+	m.Write([]byte("hello\nwor"))
+	if got, e := s.ReadUntilContext(timeout(), []string{"END"}); got != "hello\nwor" ||
+	   e != context.DeadlineExceeded {
+		t.Errorf("ReadUntilContext: %+q, %v", got, e)
+	}
+
+	// nobody reads the master, the pty fills up
+	data := make([]byte, 1 << 20)
+	if n, e := s.WriteContext(timeout(), data); n == 0 || n == len(data) ||
+	   e != context.DeadlineExceeded {
+		t.Errorf("WriteContext: %d of %d, %v", n, len(data), e)
+	}
+}
+
+func TestRfc2217Write(t *testing.T) {
+	for _, c := range []struct {
+		sent, n, cut int
+	}{
+		{0, 0, 0}, {1, 1, 0}, {2, 2, 1}, {3, 2, 0}, {4, 3, 0}, {5, 3, 0},
+	} {
+		if n, cut := telnetSent([]byte("a\xffb"), c.sent); n != c.n || cut != c.cut {
+			t.Errorf("telnetSent(%d) = %d, %d", c.sent, n, cut)
+		}
+	}
+
+	conn, server := net.Pipe()
+	defer server.Close()
+	port := &rfc2217Port{netPort: &netPort{conn: conn, write_timeout: 20 * time.Millisecond}}
+	defer port.Close()
+
+	got := make(chan []byte)
+	go func() {
+		buf := make([]byte, 2)
+		io.ReadFull(server, buf) // then stall
+		got <- buf
+	}()
+	if n, e := port.Write([]byte("a\xffb")); n != 2 || e != PortTimeoutError {
+		t.Errorf("cut Write: %d, %v", n, e)
+	}
+	if buf := <- got; string(buf) != "a\xff" {
+		t.Fatalf("before the cut: %+q", buf)
+	}
+	go func() {
+		buf := make([]byte, 2)
+		io.ReadFull(server, buf)
+		got <- buf
+	}()
+	if n, e := port.Write([]byte("c")); n != 1 || e != nil {
+		t.Errorf("next Write: %d, %v", n, e)
+	}
+	if buf := <- got; string(buf) != "\xffc" {
+		t.Errorf("after the cut: %+q", buf)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	cfg := DefaultPortConfig()
+	cfg.ReadTimeout = time.Second
+	m, e := OpenPty(cfg)
+	if e != nil {
+		t.Skip(e)
+	}
+	defer m.Close()
+	cfg.Exclusive = false
+	s, e := OpenWithConfig(m.Peer(), cfg)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer s.Close()
+
+	if d := s.CharTime(); d != 10 * time.Second / 9600 {
+		t.Errorf("CharTime() at 9600 8N1 = %v", d)
+	}
+	if d := s.FrameGap(); d != time.Duration(3.5 * float64(s.CharTime())) {
+		t.Errorf("FrameGap() = %v", d)
+	}
+	if e = s.SetInterByteTimeout(20 * time.Millisecond); e != nil {
+		t.Fatal(e)
+	}
+	if d := s.FrameGap(); d != 20 * time.Millisecond {
+		t.Errorf("FrameGap() = %v with InterByteTimeout()", d)
+	}
+
+	go func() {
+		m.Write([]byte("abc"))
+		time.Sleep(50 * time.Millisecond)
+		m.Write([]byte("de"))
+	}()
+	for _, want := range []string{"abc", "de"} {
+		if frame, e := s.ReadFrame(16); string(frame) != want || e != nil {
+			t.Errorf("ReadFrame: %+q, %v, want %+q", frame, e, want)
+		}
+	}
+	m.Write([]byte("xyz"))
+	for _, want := range []string{"xy", "z"} {
+		if frame, e := s.ReadFrame(2); string(frame) != want || e != nil {
+			t.Errorf("ReadFrame(2): %+q, %v, want %+q", frame, e, want)
+		}
+	}
+	s.SetReadTimeout(NonBlockingTimeout)
+	if frame, e := s.ReadFrame(16); frame != nil || e != nil {
+		t.Errorf("non-blocking ReadFrame: %+q, %v", frame, e)
+	}
+}
+
+func TestListPorts(t *testing.T) {
+	root := t.TempDir()
+	sysfs, dev := root + "/sys", root + "/dev"
+	class := sysfs + "/class/tty"
+	os.MkdirAll(class, 0755)
+	os.MkdirAll(dev, 0755)
+	var tty = func(name, numbers, device, typ string) {
+		dir := sysfs + "/devices/" + name
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(dir + "/dev", []byte(numbers + "\n"), 0644)
+		if device != "" {
+			os.MkdirAll(device, 0755)
+			os.Symlink(device, dir + "/device")
+		}
+		if typ != "" {
+			os.WriteFile(dir + "/type", []byte(typ + "\n"), 0644)
+		}
+		os.Symlink(dir, class + "/" + name)
+	}
+	var node = func(name string, major, minor int) {
+		e := syscall.Mknod(dev + "/" + name, syscall.S_IFCHR | 0600, major << 8 | minor)
+		if e != nil {
+			t.Skip("mknod:", e)
+		}
+	}
+	tty("tty1", "4:1", "", "") // a virtual console
+	tty("ttyS0", "4:64", sysfs + "/devices/platform/serial8250", "0") // no UART
+	tty("ttyS1", "4:65", sysfs + "/devices/platform/serial8250", "4")
+	tty("ttyUSB0", "188:0", sysfs + "/devices/usb1/1-1/1-1:1.0/ttyUSB0", "")
+	tty("ttyACM0", "166:0", sysfs + "/devices/usb1/1-2/1-2:1.0", "") // no node
+	node("tty1", 4, 1)
+	node("ttyS0", 4, 64)
+	node("ttyS1", 4, 65)
+	node("ttyUSB0", 4, 66) // stale
+
+	ports, e := listPorts(sysfs, dev)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(ports) != 1 || ports[0].Name != "ttyS1" || ports[0].Device != dev + "/ttyS1" ||
+	   ports[0].Major != 4 || ports[0].Minor != 65 {
+		t.Errorf("listPorts: %+v", ports)
+	}
+}
+
+func TestBreak(t *testing.T) {
+	m, e := OpenPty(DefaultPortConfig())
+	if e != nil {
+		t.Skip(e)
+	}
+	defer m.Close()
+
+	if e = m.SetBreak(true); e != nil {
+		t.Fatal(e)
+	}
+	if e = m.SetBreak(false); e != nil {
+		t.Fatal(e)
+	}
+	for _, d := range []time.Duration{time.Millisecond, 20 * time.Millisecond} {
+		if actual, e := m.BreakFor(d); e != nil || actual < d {
+			t.Errorf("BreakFor(%v) = %v, %v", d, actual, e)
+		}
+	}
+}
+
 /* EOF */
@@ -0,0 +1,101 @@
+package sio
+
+import "time"
+
+// Read and write timeouts come in three flavours:
+//	NonBlockingTimeout	- return at once with whatever is there
+//	InfiniteTimeout		- wait as long as it takes (any negative value)
+//	anything positive	- wait up to that long
+const NonBlockingTimeout time.Duration = 0
+const InfiniteTimeout time.Duration = -1
+
+// timeout is pyserial's Timeout: one deadline for a whole operation
+type timeout struct {
+	duration time.Duration
+	target time.Time
+}
+
+func newTimeout(duration time.Duration) *timeout {
+	return &timeout{duration: duration, target: time.Now().Add(duration)}
+}
+
+func (self *timeout) isInfinite() bool {
+	return self.duration < 0
+}
+func (self *timeout) isNonBlocking() bool {
+	return self.duration == 0
+}
+func (self *timeout) expired() bool {
+	return !self.isInfinite() && !time.Now().Before(self.target)
+}
+
+// left is never negative for finite timeouts
+func (self *timeout) left() time.Duration {
+	if self.isInfinite() {
+		return InfiniteTimeout
+	}
+	if d := time.Until(self.target); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// seconds is what poll2() wants
+func (self *timeout) seconds() float64 {
+	if self.isInfinite() {
+		return NoSelectTimeout
+	}
+	return self.left().Seconds()
+}
+
+// ReadTimeout and WriteTimeout are taken once per call: a new one
+// applies from the next Read() or Write() on
+func (self *Port) ReadTimeout() time.Duration {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	return self.read_timeout
+}
+func (self *Port) WriteTimeout() time.Duration {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	return self.write_timeout
+}
+
+func (self *Port) SetReadTimeout(d time.Duration) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if d < 0 {
+		d = InfiniteTimeout
+	}
+	self.read_timeout = d
+}
+func (self *Port) SetWriteTimeout(d time.Duration) {
+	self.lock.Lock(); defer self.lock.Unlock()
+
+	if d < 0 {
+		d = InfiniteTimeout
+	}
+	self.write_timeout = d
+}
+
+// SetDeadline sets both timeouts to what is left until t, the zero t
+// means InfiniteTimeout; a t in the past makes I/O non-blocking.
+//
+// Deprecated: the timeouts are per call, not a point in time; use
+// SetReadTimeout() and SetWriteTimeout(), or ReadContext() and
+// WriteContext() with context.WithDeadline().
+func (self *Port) SetDeadline(t time.Time) error {
+	if !self.IsOpen() { return PortNotOpenError; }
+	d := InfiniteTimeout
+	if !t.IsZero() {
+		d = time.Until(t)
+		if d < 0 {
+			d = NonBlockingTimeout
+		}
+	}
+	self.SetReadTimeout(d)
+	self.SetWriteTimeout(d)
+	return nil
+}
+
+/* EOF */
@@ -0,0 +1,174 @@
+package sio
+
+import (
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SerialPort is what OpenURL() returns: a Port or some other transport
+type SerialPort interface {
+	io.ReadWriteCloser
+	String() string
+}
+
+// URLHandler opens a port for its scheme. The cfg is already filled from
+// the URL query (see ConfigFromQuery) on top of DefaultPortConfig().
+type URLHandler func(u *url.URL, cfg PortConfig) (SerialPort, error)
+
+var urlHandlers = map[string]URLHandler{}
+var urlLock sync.Mutex
+
+// RegisterURLHandler makes OpenURL() use h for "scheme://..." URLs.
+// The empty scheme is for plain device paths.
+func RegisterURLHandler(scheme string, h URLHandler) {
+	urlLock.Lock() ; defer urlLock.Unlock()
+	urlHandlers[strings.ToLower(scheme)] = h
+}
+
+func getURLHandler(scheme string) (h URLHandler, found bool) {
+	urlLock.Lock() ; defer urlLock.Unlock()
+	h, found = urlHandlers[strings.ToLower(scheme)]
+	return
+}
+
+func init() {
+	RegisterURLHandler("", openDeviceURL)
+	RegisterURLHandler("loop", openLoopURL)
+	RegisterURLHandler("pty", openPtyURL)
+	RegisterURLHandler("tcp", openTcpURL)
+	RegisterURLHandler("rfc2217", openRfc2217URL)
+}
+
+// OpenURL opens "/dev/ttyUSB0?baud=115200&parity=E", "loop://", "pty://",
+// "tcp://host:port", "rfc2217://host:port" or whatever is registered.
+func OpenURL(s string) (port SerialPort, e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	u, e := url.Parse(s)
+	assert(e, "OpenURL(%+q): %w", s, e)
+
+	h, found := getURLHandler(u.Scheme)
+	assertb(found, "OpenURL(%+q): unknown scheme %+q", s, u.Scheme)
+
+	cfg := DefaultPortConfig()
+	e = ConfigFromQuery(u.Query(), &cfg)
+	assert(e, "OpenURL(%+q): %w", s, e)
+
+	return h(u, cfg)
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	switch strings.ToLower(s) {
+	case "none", "inf", "infinite":
+		return InfiniteTimeout, nil
+	}
+	if d, e := time.ParseDuration(s); e == nil {
+		return d, nil
+	}
+	seconds, e := strconv.ParseFloat(s, 64) // pyserial style
+	if e != nil {
+		return 0, e
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// ConfigFromQuery applies known URL query parameters to cfg:
+// mode=115200,8N1 baud= bytesize= parity= stopbits= xonxoff= rtscts=
+// dsrdtr= dtr= rts= hupcl= mark_errors= exclusive= keep_settings=
+// timeout= write_timeout= (100ms, 0.1 or none) inter_byte_timeout= (100ms
+// or 0.1, none makes no sense there).
+// Unknown parameters are left for the scheme handler.
+func ConfigFromQuery(q url.Values, cfg *PortConfig) (e error) {
+	defer func() {
+		if state := recover(); state != nil {
+			e = WrapError(state.(error))
+		}
+	}()
+
+	var flag = func(name string, v *bool) {
+		if s := q.Get(name); s != "" {
+			*v, e = strconv.ParseBool(s)
+			assert(e, "%s=%+q: %w", name, s, e)
+		}
+	}
+	var number = func(name string) (n uint64, found bool) {
+		if s := q.Get(name); s != "" {
+			n, e = strconv.ParseUint(s, 10, 32)
+			assert(e, "%s=%+q: %w", name, s, e)
+			return n, true
+		}
+		return 0, false
+	}
+	var timeout = func(name string, v *time.Duration) {
+		if s := q.Get(name); s != "" {
+			*v, e = parseTimeout(s)
+			assert(e, "%s=%+q: %w", name, s, e)
+		}
+	}
+
+	var level = func(name string, v *LineLevel) {
+		if s := q.Get(name); s != "" {
+			var set bool
+			set, e = strconv.ParseBool(s)
+			assert(e, "%s=%+q: %w", name, s, e)
+			*v = LINE_LOW
+			if set {
+				*v = LINE_HIGH
+			}
+		}
+	}
+
+	if s := q.Get("mode"); s != "" {
+		e = cfg.SetMode(s)
+		assert(e, "mode=%+q: %w", s, e)
+	}
+	if n, found := number("baud"); found {
+		cfg.Speed = BitRate(n)
+	}
+	if n, found := number("bytesize"); found {
+		cfg.CharSize = CharSize(n)
+	}
+	if s := q.Get("parity"); s != "" {
+		p, ok := ParseParity(s[0])
+		assertb(ok && len(s) == 1, "parity=%+q", s)
+		cfg.Parity = p
+	}
+	if n, found := number("stopbits"); found {
+		cfg.StopBits = StopBits(n)
+	}
+	flag("xonxoff", &cfg.XonXoff)
+	flag("rtscts", &cfg.RtsCts)
+	flag("dsrdtr", &cfg.DsrDtr)
+	flag("exclusive", &cfg.Exclusive)
+	flag("keep_settings", &cfg.KeepSettings)
+	flag("hupcl", &cfg.HangupOnClose)
+	flag("mark_errors", &cfg.MarkErrors)
+	level("dtr", &cfg.DTR)
+	level("rts", &cfg.RTS)
+	timeout("timeout", &cfg.ReadTimeout)
+	timeout("write_timeout", &cfg.WriteTimeout)
+	timeout("inter_byte_timeout", &cfg.InterByteTimeout)
+	assertb(cfg.InterByteTimeout >= 0,
+		"inter_byte_timeout=%+q: must be a duration, not infinite",
+		q.Get("inter_byte_timeout"))
+
+	return cfg.Validate()
+}
+
+func openDeviceURL(u *url.URL, cfg PortConfig) (SerialPort, error) {
+	p, e := OpenWithConfig(u.Path, cfg)
+	if e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+/* EOF */
@@ -0,0 +1,70 @@
+package sio
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// WakeSignal interrupts an ioctl blocked on a thread of its own, see
+// wakeable. It gets a handler without SA_RESTART, as the Go runtime's
+// would have the kernel restart the ioctl. Pick another one before the
+// first WaitModemChange() if the program has a use for this one.
+var WakeSignal = syscall.Signal(62) // SIGRTMAX - 2
+
+// WakeRetry is how often the signal is sent again: the first one may
+// come before the thread is in the ioctl
+const WakeRetry = 10 * time.Millisecond
+
+var wakeOnce sync.Once
+var wakeError error
+
+// wakeable runs call on a locked OS thread. call is to give up once it
+// gets EINTR with stop set.
+type wakeable struct {
+	tid int
+	stop int32
+	done chan error
+}
+
+func startWakeable(call func(stop *int32) error) (*wakeable, error) {
+	wakeOnce.Do(func() {
+		wakeError = installWakeup(WakeSignal)
+	})
+	if wakeError != nil {
+		return nil, wakeError
+	}
+	self := &wakeable{done: make(chan error, 1)}
+	tid := make(chan int)
+	go func() {
+		// never unlocked: the thread goes away with the goroutine,
+		// and so does a signal still on its way to it
+		runtime.LockOSThread()
+		tid <- syscall.Gettid()
+		self.done <- call(&self.stop)
+	}()
+	self.tid = <- tid
+	return self, nil
+}
+
+// wake makes call return and waits for it
+func (self *wakeable) wake() error {
+	atomic.StoreInt32(&self.stop, 1)
+	pid := syscall.Getpid()
+	for {
+		syscall.Tgkill(pid, self.tid, WakeSignal)
+		select {
+		case e := <- self.done:
+			return e
+		case <- time.After(WakeRetry):
+		}
+	}
+}
+
+func woken(stop *int32) bool {
+	return stop != nil && atomic.LoadInt32(stop) != 0
+}
+
+/* EOF */